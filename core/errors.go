@@ -0,0 +1,23 @@
+package core
+
+import "fmt"
+
+// CleanupError is an error encountered during a single phase of a
+// CleanupTask run, tagged with the repository and phase it occurred in so
+// that metrics and reports can attribute it. Repository is empty for
+// errors that occur before a specific repository is known, such as
+// listing pods or listing a target's repositories.
+type CleanupError struct {
+	Repository string
+	Phase      string
+	Err        error
+}
+
+func (e *CleanupError) Error() string {
+	if e.Repository == "" {
+		return fmt.Sprintf("%s: %s", e.Phase, e.Err)
+	}
+	return fmt.Sprintf("%s (repository %s): %s", e.Phase, e.Repository, e.Err)
+}
+
+func (e *CleanupError) Unwrap() error { return e.Err }
@@ -0,0 +1,104 @@
+package core
+
+import "sort"
+
+// ManifestResolver is implemented by a RegistryClient that can resolve
+// multi-arch manifest lists (OCI image indexes / Docker manifest lists) to
+// their child manifest digests. It is optional: backends that only ever
+// deal in single-arch images don't implement it, and manifest-graph
+// awareness is simply skipped for them.
+type ManifestResolver interface {
+	// ResolveManifest reports the child digests of digest if it is a
+	// multi-arch manifest list or OCI image index, or ok=false if digest
+	// is a plain single-arch image manifest.
+	ResolveManifest(repository, digest string) (children []string, ok bool)
+}
+
+// manifestGraph captures the parent/child relationships between the
+// digests in a single repository's image listing, so that in-use status
+// and deletion order can account for multi-arch manifest lists.
+type manifestGraph struct {
+	childrenOf map[string][]string
+	parentOf   map[string]string
+}
+
+// buildManifestGraph resolves the manifest of every image in a repository
+// listing, recording which digests are multi-arch indexes and which are
+// their children. If resolver is nil, it returns an empty graph and every
+// image is treated as a standalone, single-arch manifest.
+func buildManifestGraph(repository string, images []*Image, resolver ManifestResolver) *manifestGraph {
+	graph := &manifestGraph{
+		childrenOf: map[string][]string{},
+		parentOf:   map[string]string{},
+	}
+
+	if resolver == nil {
+		return graph
+	}
+
+	for _, image := range images {
+		children, ok := resolver.ResolveManifest(repository, image.Digest)
+		if !ok {
+			continue
+		}
+
+		graph.childrenOf[image.Digest] = children
+		for _, child := range children {
+			graph.parentOf[child] = image.Digest
+		}
+	}
+
+	return graph
+}
+
+// propagate extends a set of in-use digests so that an in-use child also
+// marks its parent index in use, and an in-use index also marks all of its
+// children in use, keeping a multi-arch image's in-use status consistent
+// across the whole family of manifests.
+func (g *manifestGraph) propagate(inUseDigests map[string]bool) {
+	for changed := true; changed; {
+		changed = false
+
+		for digest := range inUseDigests {
+			if parent, ok := g.parentOf[digest]; ok && !inUseDigests[parent] {
+				inUseDigests[parent] = true
+				changed = true
+			}
+
+			for _, child := range g.childrenOf[digest] {
+				if !inUseDigests[child] {
+					inUseDigests[child] = true
+					changed = true
+				}
+			}
+		}
+	}
+}
+
+// orderForDeletion sorts images so that every child manifest of a
+// multi-arch index precedes that index, allowing a single
+// BatchRemoveImages call to remove an index together with its children
+// without ever orphaning a child.
+func (g *manifestGraph) orderForDeletion(images []*Image) []*Image {
+	ordered := append([]*Image{}, images...)
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return g.depth(ordered[i].Digest) > g.depth(ordered[j].Digest)
+	})
+
+	return ordered
+}
+
+// depth returns how many manifest-list levels separate digest from a
+// top-level (parentless) manifest.
+func (g *manifestGraph) depth(digest string) int {
+	depth := 0
+	for {
+		parent, ok := g.parentOf[digest]
+		if !ok {
+			return depth
+		}
+		digest = parent
+		depth++
+	}
+}
@@ -0,0 +1,279 @@
+// Package core implements the cleanup logic that compares images present in
+// a registry target against images currently in use by running Kubernetes
+// pods, and removes the ones that are safe to delete.
+package core
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// KubeClient abstracts the subset of the Kubernetes API used to discover
+// which images are currently in use by running pods.
+type KubeClient interface {
+	ListAllPods(namespaces []*string) ([]*v1.Pod, error)
+}
+
+// CleanupTask describes a single cleanup run: which namespaces to inspect
+// for in-use images, which registry targets to clean, and how the unused
+// images found in those targets should be retained or removed.
+type CleanupTask struct {
+	KubeNamespaces []*string
+	Targets        []*CleanupTarget
+
+	// MaxImages is the number of most-recently-pushed unused images to
+	// keep in each repository when Retention is not set or its KeepLastN
+	// is 0.
+	MaxImages int
+
+	// Retention, when set, takes precedence over MaxImages for deciding
+	// which unused images are safe to keep.
+	Retention *RetentionPolicy
+
+	// Workloads, when set by the caller, additionally protects images referenced by workload pod templates
+	// that currently have no running pods, such as a Deployment scaled to
+	// zero or a suspended CronJob.
+	Workloads *WorkloadImageCollector
+
+	// DryRun, when true, makes RemoveOldImages compute and report exactly
+	// what would be removed without calling BatchRemoveImages, so that the
+	// effect of a retention policy can be reviewed before it takes effect.
+	DryRun bool
+
+	// TriggerSource identifies what started this run. It defaults to
+	// TriggerSchedule when left unset, so callers that don't care about
+	// the distinction (including most existing tests) don't need to set
+	// it.
+	TriggerSource TriggerSource
+}
+
+// RemoveOldImages scans the given Kubernetes namespaces for images that are
+// currently referenced by a pod, then removes images from each configured
+// target that are not in use and not protected by the task's retention
+// rules. It returns a CleanupReport describing what was found and done in
+// every repository, and one error per failed operation rather than failing
+// fast, so that a problem with one target or repository does not prevent
+// cleanup of the others.
+func (t *CleanupTask) RemoveOldImages(kubeClient KubeClient) (*CleanupReport, []error) {
+	triggerSource := t.TriggerSource
+	if triggerSource == "" {
+		triggerSource = TriggerSchedule
+	}
+
+	var errs []error
+	report := &CleanupReport{GeneratedAt: time.Now(), TriggerSource: triggerSource}
+
+	pods, err := kubeClient.ListAllPods(t.KubeNamespaces)
+	if err != nil {
+		return report, append(errs, &CleanupError{Phase: "list-pods", Err: err})
+	}
+
+	imageRefs := podImageRefs(pods)
+
+	if t.Workloads != nil {
+		workloadRefs, pullSecrets, err := t.Workloads.Collect(t.KubeNamespaces)
+		if err != nil {
+			errs = append(errs, &CleanupError{Phase: "collect-workloads", Err: err})
+		} else {
+			imageRefs = append(imageRefs, workloadRefs...)
+			applyPullSecretAuth(t.Targets, pullSecrets)
+		}
+	}
+
+	for _, target := range t.Targets {
+		inUse := inUseImageRefs(imageRefs, target.Client)
+
+		repos, err := target.Client.ListRepositories(target.Repositories)
+		if err != nil {
+			errs = append(errs, &CleanupError{Phase: "list-repositories", Err: fmt.Errorf("%s target: %s", target.Backend, err)})
+			continue
+		}
+
+		for _, repo := range repos {
+			images, err := target.Client.ListImages(repo.Name)
+			if err != nil {
+				errs = append(errs, &CleanupError{Repository: repo.Name, Phase: "list-images", Err: err})
+				continue
+			}
+
+			resolver, _ := target.Client.(ManifestResolver)
+			graph := buildManifestGraph(repo.Name, images, resolver)
+
+			digestInUse := map[string]bool{}
+			for _, image := range images {
+				if imageInUse(repo.Name, image, inUse) {
+					digestInUse[image.Digest] = true
+				}
+			}
+			graph.propagate(digestInUse)
+
+			digestKept := map[string]bool{}
+			if t.Retention != nil {
+				for _, image := range images {
+					if t.Retention.keeps(repo.Name, image) {
+						digestKept[image.Digest] = true
+					}
+				}
+				graph.propagate(digestKept)
+			}
+
+			repoReport := &RepositoryReport{Repository: repo.Name, Scanned: len(images)}
+			for _, image := range images {
+				if imageInUse(repo.Name, image, inUse) || digestInUse[image.Digest] {
+					repoReport.InUse++
+				}
+			}
+
+			toRemove := t.imagesToRemove(repo.Name, images, inUse, digestInUse, digestKept)
+			repoReport.Eligible = len(toRemove)
+
+			for _, image := range toRemove {
+				repoReport.BytesReclaimed += image.SizeInBytes
+				repoReport.Entries = append(repoReport.Entries, ReportEntry{
+					Repository: repo.Name,
+					Digest:     image.Digest,
+					Tags:       image.Tags,
+					PushedAt:   image.PushedAt,
+					Reason:     t.removalReason(),
+				})
+			}
+
+			if len(toRemove) > 0 && !t.DryRun {
+				toRemove = graph.orderForDeletion(toRemove)
+
+				if err := target.Client.BatchRemoveImages(repo.Name, toRemove); err != nil {
+					errs = append(errs, &CleanupError{Repository: repo.Name, Phase: "remove-images", Err: err})
+				} else {
+					repoReport.Deleted = len(toRemove)
+				}
+			}
+
+			report.Repositories = append(report.Repositories, repoReport)
+		}
+	}
+
+	return report, errs
+}
+
+// removalReason explains, in terms of the task's active retention
+// configuration, why an image outside of it was eligible for removal.
+func (t *CleanupTask) removalReason() string {
+	if t.Retention != nil && t.Retention.KeepLastN > 0 {
+		return fmt.Sprintf("not in use and outside the %d most recently pushed images kept by the retention policy", t.Retention.KeepLastN)
+	}
+	return fmt.Sprintf("not in use and outside the %d most recently pushed images", t.MaxImages)
+}
+
+// applyPullSecretAuth configures any target whose RegistryClient implements
+// CredentialedClient to authenticate with the imagePullSecret credentials
+// decoded for its registry host, if one was found. This is how a cluster's
+// imagePullSecrets reach a private registry that pkg/registry.New couldn't
+// otherwise authenticate against.
+func applyPullSecretAuth(targets []*CleanupTarget, pullSecrets map[string]RegistryAuth) {
+	for _, target := range targets {
+		credentialed, ok := target.Client.(CredentialedClient)
+		if !ok {
+			continue
+		}
+
+		if auth, ok := pullSecrets[credentialed.RegistryHost()]; ok {
+			credentialed.Authenticate(auth)
+		}
+	}
+}
+
+// podImageRefs returns the raw image reference strings used by a
+// container or init container in the given pods. Ephemeral containers are
+// not inspected (see podSpecImages).
+func podImageRefs(pods []*v1.Pod) []string {
+	var refs []string
+
+	for _, pod := range pods {
+		refs = append(refs, podSpecImages(pod.Spec)...)
+	}
+
+	return refs
+}
+
+// inUseImageRefs resolves the given raw image references against a
+// target's backend, returning the set of "repository:tag" and
+// "repository@digest" keys that are in use. References belonging to a
+// different backend are ignored, since ParseImageRef reports ok=false for
+// references it doesn't own. Each tag reference is additionally resolved
+// to its current digest, so that retention stays accurate even after a tag
+// such as "latest" is repointed following a pod's pull of it.
+func inUseImageRefs(imageRefs []string, client RegistryClient) map[string]bool {
+	refs := make(map[string]bool)
+
+	for _, imageRef := range imageRefs {
+		repo, tag, digest, ok := client.ParseImageRef(imageRef)
+		if !ok {
+			continue
+		}
+
+		if tag != "" {
+			refs[repo+":"+tag] = true
+			if resolved, ok := client.ResolveDigest(repo, tag); ok {
+				refs[repo+"@"+resolved] = true
+			}
+		}
+
+		if digest != "" {
+			refs[repo+"@"+digest] = true
+		}
+	}
+
+	return refs
+}
+
+// imagesToRemove returns the images in a repository that are safe to
+// delete: they are not referenced by a running pod, they are not part of a
+// multi-arch manifest list that is (via digestInUse), they are not
+// protected by the task's retention policy themselves or as part of a
+// multi-arch manifest list that is (via digestKept), and they fall outside
+// the number of newest images the task is configured to keep.
+func (t *CleanupTask) imagesToRemove(repoName string, images []*Image, inUse map[string]bool, digestInUse map[string]bool, digestKept map[string]bool) []*Image {
+	var candidates []*Image
+
+	for _, image := range images {
+		if imageInUse(repoName, image, inUse) || digestInUse[image.Digest] {
+			continue
+		}
+		if digestKept[image.Digest] {
+			continue
+		}
+		candidates = append(candidates, image)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].PushedAt.After(candidates[j].PushedAt)
+	})
+
+	keepLastN := t.MaxImages
+	if t.Retention != nil && t.Retention.KeepLastN > 0 {
+		keepLastN = t.Retention.KeepLastN
+	}
+
+	if len(candidates) <= keepLastN {
+		return nil
+	}
+
+	return candidates[keepLastN:]
+}
+
+func imageInUse(repoName string, image *Image, inUse map[string]bool) bool {
+	if image.Digest != "" && inUse[repoName+"@"+image.Digest] {
+		return true
+	}
+
+	for _, tag := range image.Tags {
+		if inUse[repoName+":"+tag] {
+			return true
+		}
+	}
+
+	return false
+}
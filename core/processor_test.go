@@ -2,11 +2,16 @@ package core
 
 import (
 	"fmt"
+	"strings"
 	"testing"
-
-	"github.com/aws/aws-sdk-go/service/ecr"
+	"time"
 
 	"k8s.io/client-go/pkg/api/v1"
+	appsv1beta1 "k8s.io/client-go/pkg/apis/apps/v1beta1"
+	batchv1 "k8s.io/client-go/pkg/apis/batch/v1"
+	batchv2alpha1 "k8s.io/client-go/pkg/apis/batch/v2alpha1"
+	extensionsv1beta1 "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+	metav1 "k8s.io/client-go/pkg/apis/meta/v1"
 )
 
 // mockKubeClient is used to verify that the Kubernetes client is being called
@@ -21,25 +26,6 @@ type mockKubeClient struct {
 	listAllPodsError  error
 }
 
-// mockECRClient is used to verify that the Kubernetes client is being called
-// with the correct arguments, and that the return values are being handled
-// correctly by its consumers.
-type mockECRClient struct {
-	t *testing.T
-
-	expectedRepositoryNames []string
-
-	listRepositoriesResult []*ecr.Repository
-	listRepositoriesError  error
-
-	expectedImagesRepositoryName string
-	listImagesResult             []*ecr.ImageDetail
-	listImagesError              error
-
-	expectedImagesToRemove []*ecr.ImageDetail
-	batchRemoveImagesError error
-}
-
 func (m *mockKubeClient) ListAllPods(namespace []*string) ([]*v1.Pod, error) {
 	if len(namespace) != len(m.expectedNamespace) {
 		m.t.Errorf("Expected namespaces to contain %d elements, but it contains %d", len(m.expectedNamespace), len(namespace))
@@ -54,42 +40,171 @@ func (m *mockKubeClient) ListAllPods(namespace []*string) ([]*v1.Pod, error) {
 	return m.listAllPodsResult, m.listAllPodsError
 }
 
-func (m *mockECRClient) ListRepositories(repositoryNames []*string) ([]*ecr.Repository, error) {
+// mockRegistryClient is a core.RegistryClient used to verify that each
+// backend is being called with the correct arguments, and that the return
+// values are being handled correctly by its consumers. hostSuffix lets a
+// single mock type stand in for any backend in cross-backend tests by
+// matching image references whose host ends in it.
+type mockRegistryClient struct {
+	t *testing.T
+
+	hostSuffix string
+
+	expectedRepositoryNames []string
+	listRepositoriesResult  []*Repository
+	listRepositoriesError   error
+
+	expectedImagesRepositoryName string
+	listImagesResult             []*Image
+	listImagesError              error
+
+	expectedImagesToRemove []*Image
+	batchRemoveImagesError error
+
+	// assertBatchRemoveNotCalled fails the test if BatchRemoveImages is
+	// called at all, for asserting that a dry-run task never removes
+	// anything.
+	assertBatchRemoveNotCalled bool
+
+	resolveDigestResult string
+	resolveDigestOK     bool
+
+	// getManifestResult maps a digest to the child digests of its
+	// manifest, for digests that are multi-arch manifest lists or OCI
+	// image indexes. A digest with no entry is treated as a plain
+	// single-arch manifest.
+	getManifestResult map[string][]string
+
+	// registryHost and authenticateCalls support CredentialedClient,
+	// recording the credentials applyPullSecretAuth passed in.
+	registryHost      string
+	authenticateCalls []RegistryAuth
+}
+
+func (m *mockRegistryClient) ListRepositories(repositoryNames []string) ([]*Repository, error) {
 	if len(repositoryNames) != len(m.expectedRepositoryNames) {
 		m.t.Errorf("Expected repository names to contain %d elements, but it contains %d", len(m.expectedRepositoryNames), len(repositoryNames))
 	}
 
 	for i := range repositoryNames {
-		if *repositoryNames[i] != m.expectedRepositoryNames[i] {
-			m.t.Errorf("Expected repository name at index %d to be %v, but was %v", i, m.expectedRepositoryNames[i], *repositoryNames[i])
+		if repositoryNames[i] != m.expectedRepositoryNames[i] {
+			m.t.Errorf("Expected repository name at index %d to be %v, but was %v", i, m.expectedRepositoryNames[i], repositoryNames[i])
 		}
 	}
 
 	return m.listRepositoriesResult, m.listRepositoriesError
 }
 
-func (m *mockECRClient) ListImages(repositoryName *string) ([]*ecr.ImageDetail, error) {
-	if m.expectedImagesRepositoryName != *repositoryName {
-		m.t.Errorf("Expected repository name to be %v, but was %v", m.expectedImagesRepositoryName, *repositoryName)
+func (m *mockRegistryClient) ListImages(repositoryName string) ([]*Image, error) {
+	if m.expectedImagesRepositoryName != repositoryName {
+		m.t.Errorf("Expected repository name to be %v, but was %v", m.expectedImagesRepositoryName, repositoryName)
 	}
 
 	return m.listImagesResult, m.listImagesError
 }
 
-func (m *mockECRClient) BatchRemoveImages(images []*ecr.ImageDetail) error {
+func (m *mockRegistryClient) BatchRemoveImages(repositoryName string, images []*Image) error {
+	if m.assertBatchRemoveNotCalled {
+		m.t.Errorf("Expected BatchRemoveImages not to be called, but it was called for repository %v with %d images", repositoryName, len(images))
+	}
+
 	if len(images) != len(m.expectedImagesToRemove) {
 		m.t.Errorf("Expected images to contain %d elements, but it contains %d", len(m.expectedImagesToRemove), len(images))
 	}
 
 	for i := range images {
-		if *images[i].ImageDigest != *m.expectedImagesToRemove[i].ImageDigest {
-			m.t.Errorf("Expected image digest at index %d to be %v, but was %v", i, m.expectedImagesToRemove[i].ImageDigest, *images[i].ImageDigest)
+		if images[i].Digest != m.expectedImagesToRemove[i].Digest {
+			m.t.Errorf("Expected image digest at index %d to be %v, but was %v", i, m.expectedImagesToRemove[i].Digest, images[i].Digest)
 		}
 	}
 
 	return m.batchRemoveImagesError
 }
 
+func (m *mockRegistryClient) ParseImageRef(image string) (repo, tag, digest string, ok bool) {
+	suffix := m.hostSuffix
+	if suffix == "" {
+		suffix = ".dkr.ecr.region.amazonaws.com"
+	}
+
+	slash := strings.Index(image, "/")
+	if slash < 0 || !strings.HasSuffix(image[:slash], suffix) {
+		return "", "", "", false
+	}
+
+	rest := image[slash+1:]
+	if at := strings.Index(rest, "@"); at >= 0 {
+		return rest[:at], "", rest[at+1:], true
+	}
+	if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+		return rest[:colon], rest[colon+1:], "", true
+	}
+
+	return rest, "", "", true
+}
+
+func (m *mockRegistryClient) ResolveDigest(repository, tag string) (string, bool) {
+	return m.resolveDigestResult, m.resolveDigestOK
+}
+
+func (m *mockRegistryClient) ResolveManifest(repository, digest string) ([]string, bool) {
+	children, ok := m.getManifestResult[digest]
+	return children, ok
+}
+
+func (m *mockRegistryClient) RegistryHost() string {
+	return m.registryHost
+}
+
+func (m *mockRegistryClient) Authenticate(auth RegistryAuth) {
+	m.authenticateCalls = append(m.authenticateCalls, auth)
+}
+
+// mockWorkloadClient is a core.WorkloadClient used to verify that scanning
+// workload pod templates, rather than only running pods, picks up images
+// used by workloads with no running pods.
+type mockWorkloadClient struct {
+	deployments  []*appsv1beta1.Deployment
+	statefulSets []*appsv1beta1.StatefulSet
+	daemonSets   []*extensionsv1beta1.DaemonSet
+	replicaSets  []*extensionsv1beta1.ReplicaSet
+	jobs         []*batchv1.Job
+	cronJobs     []*batchv2alpha1.CronJob
+
+	// pullSecretResult is the raw .dockerconfigjson that the mock's
+	// GetImagePullSecret (which makes it a PullSecretClient) returns for
+	// any requested secret.
+	pullSecretResult []byte
+}
+
+func (m *mockWorkloadClient) GetImagePullSecret(namespace, name string) ([]byte, error) {
+	return m.pullSecretResult, nil
+}
+
+func (m *mockWorkloadClient) ListAllDeployments([]*string) ([]*appsv1beta1.Deployment, error) {
+	return m.deployments, nil
+}
+
+func (m *mockWorkloadClient) ListAllStatefulSets([]*string) ([]*appsv1beta1.StatefulSet, error) {
+	return m.statefulSets, nil
+}
+
+func (m *mockWorkloadClient) ListAllDaemonSets([]*string) ([]*extensionsv1beta1.DaemonSet, error) {
+	return m.daemonSets, nil
+}
+
+func (m *mockWorkloadClient) ListAllReplicaSets([]*string) ([]*extensionsv1beta1.ReplicaSet, error) {
+	return m.replicaSets, nil
+}
+
+func (m *mockWorkloadClient) ListAllJobs([]*string) ([]*batchv1.Job, error) {
+	return m.jobs, nil
+}
+
+func (m *mockWorkloadClient) ListAllCronJobs([]*string) ([]*batchv2alpha1.CronJob, error) {
+	return m.cronJobs, nil
+}
+
 func TestRemoveOldImagesWithKubeListPodsError(t *testing.T) {
 	namespace := "namespace"
 	kubeClient := &mockKubeClient{
@@ -105,14 +220,14 @@ func TestRemoveOldImagesWithKubeListPodsError(t *testing.T) {
 		KubeNamespaces: []*string{&namespace},
 	}
 
-	errs := task.RemoveOldImages(kubeClient, nil)
+	_, errs := task.RemoveOldImages(kubeClient)
 
 	if len(errs) != 1 {
 		t.Errorf("Expected errors to contain 1 element, but it contains %d", len(errs))
 	}
 }
 
-func TestRemoveOldImagesWithECRListRepositoriesError(t *testing.T) {
+func TestRemoveOldImagesWithListRepositoriesError(t *testing.T) {
 	namespace, repoName := "namespace", "repo"
 	kubeClient := &mockKubeClient{
 		t: t,
@@ -123,7 +238,7 @@ func TestRemoveOldImagesWithECRListRepositoriesError(t *testing.T) {
 		},
 	}
 
-	ecrClient := &mockECRClient{
+	registryClient := &mockRegistryClient{
 		t: t,
 
 		expectedRepositoryNames: []string{repoName},
@@ -132,18 +247,20 @@ func TestRemoveOldImagesWithECRListRepositoriesError(t *testing.T) {
 	}
 
 	task := &CleanupTask{
-		KubeNamespaces:  []*string{&namespace},
-		EcrRepositories: []*string{&repoName},
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendECR, Repositories: []string{repoName}, Client: registryClient},
+		},
 	}
 
-	errs := task.RemoveOldImages(kubeClient, ecrClient)
+	_, errs := task.RemoveOldImages(kubeClient)
 
 	if len(errs) != 1 {
 		t.Errorf("Expected errors to contain 1 element, but it contains %d", len(errs))
 	}
 }
 
-func TestRemoveOldImagesWithECRListImagesError(t *testing.T) {
+func TestRemoveOldImagesWithListImagesError(t *testing.T) {
 	namespace, repoName := "namespace", "repo"
 	kubeClient := &mockKubeClient{
 		t: t,
@@ -162,14 +279,12 @@ func TestRemoveOldImagesWithECRListImagesError(t *testing.T) {
 		},
 	}
 
-	ecrClient := &mockECRClient{
+	registryClient := &mockRegistryClient{
 		t: t,
 
 		expectedRepositoryNames: []string{repoName},
-		listRepositoriesResult: []*ecr.Repository{
-			{
-				RepositoryName: &repoName,
-			},
+		listRepositoriesResult: []*Repository{
+			{Name: repoName},
 		},
 
 		expectedImagesRepositoryName: repoName,
@@ -178,12 +293,14 @@ func TestRemoveOldImagesWithECRListImagesError(t *testing.T) {
 	}
 
 	task := &CleanupTask{
-		KubeNamespaces:  []*string{&namespace},
-		EcrRepositories: []*string{&repoName},
-		MaxImages:       1,
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendECR, Repositories: []string{repoName}, Client: registryClient},
+		},
+		MaxImages: 1,
 	}
 
-	errs := task.RemoveOldImages(kubeClient, ecrClient)
+	_, errs := task.RemoveOldImages(kubeClient)
 
 	if len(errs) != 1 {
 		t.Errorf("Expected errors to contain 1 element, but it contains %d", len(errs))
@@ -209,40 +326,38 @@ func TestRemoveOldImagesWithoutOldImagesToRemove(t *testing.T) {
 		},
 	}
 
-	ecrClient := &mockECRClient{
+	registryClient := &mockRegistryClient{
 		t: t,
 
 		expectedRepositoryNames: []string{repoName},
-		listRepositoriesResult: []*ecr.Repository{
-			{
-				RepositoryName: &repoName,
-			},
+		listRepositoriesResult: []*Repository{
+			{Name: repoName},
 		},
 
 		expectedImagesRepositoryName: repoName,
-		listImagesResult: []*ecr.ImageDetail{
-			{
-				ImageDigest: &imageDigest,
-			},
+		listImagesResult: []*Image{
+			{Digest: imageDigest},
 		},
 	}
 
 	task := &CleanupTask{
-		KubeNamespaces:  []*string{&namespace},
-		EcrRepositories: []*string{&repoName},
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendECR, Repositories: []string{repoName}, Client: registryClient},
+		},
 
 		// No need to clean up any images
 		MaxImages: 1000,
 	}
 
-	errs := task.RemoveOldImages(kubeClient, ecrClient)
+	_, errs := task.RemoveOldImages(kubeClient)
 
 	if len(errs) != 0 {
 		t.Errorf("Expected errors to be empty, but is %q", errs)
 	}
 }
 
-func TestRemoveOldImagesWithECRBatchRemoveImagesError(t *testing.T) {
+func TestRemoveOldImagesWithBatchRemoveImagesError(t *testing.T) {
 	namespace, repoName, imageDigest := "namespace", "repo", "image-digest"
 	kubeClient := &mockKubeClient{
 		t: t,
@@ -261,40 +376,36 @@ func TestRemoveOldImagesWithECRBatchRemoveImagesError(t *testing.T) {
 		},
 	}
 
-	ecrClient := &mockECRClient{
+	registryClient := &mockRegistryClient{
 		t: t,
 
 		expectedRepositoryNames: []string{repoName},
-		listRepositoriesResult: []*ecr.Repository{
-			{
-				RepositoryName: &repoName,
-			},
+		listRepositoriesResult: []*Repository{
+			{Name: repoName},
 		},
 
 		expectedImagesRepositoryName: repoName,
-		listImagesResult: []*ecr.ImageDetail{
-			{
-				ImageDigest: &imageDigest,
-			},
+		listImagesResult: []*Image{
+			{Digest: imageDigest},
 		},
 
-		expectedImagesToRemove: []*ecr.ImageDetail{
-			{
-				ImageDigest: &imageDigest,
-			},
+		expectedImagesToRemove: []*Image{
+			{Digest: imageDigest},
 		},
 		batchRemoveImagesError: fmt.Errorf(""),
 	}
 
 	task := &CleanupTask{
-		KubeNamespaces:  []*string{&namespace},
-		EcrRepositories: []*string{&repoName},
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendECR, Repositories: []string{repoName}, Client: registryClient},
+		},
 
 		// Will cause the image to be deleted
 		MaxImages: 0,
 	}
 
-	errs := task.RemoveOldImages(kubeClient, ecrClient)
+	_, errs := task.RemoveOldImages(kubeClient)
 
 	if len(errs) == 0 {
 		t.Errorf("Expected errors to contain 1 element, but it contains %d", len(errs))
@@ -320,39 +431,694 @@ func TestRemoveOldImages(t *testing.T) {
 		},
 	}
 
-	ecrClient := &mockECRClient{
+	registryClient := &mockRegistryClient{
+		t: t,
+
+		expectedRepositoryNames: []string{repoName},
+		listRepositoriesResult: []*Repository{
+			{Name: repoName},
+		},
+
+		expectedImagesRepositoryName: repoName,
+		listImagesResult: []*Image{
+			{Digest: imageDigest},
+		},
+
+		expectedImagesToRemove: []*Image{
+			{Digest: imageDigest},
+		},
+	}
+
+	task := &CleanupTask{
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendECR, Repositories: []string{repoName}, Client: registryClient},
+		},
+
+		// Will cause the image to be deleted
+		MaxImages: 0,
+	}
+
+	_, errs := task.RemoveOldImages(kubeClient)
+
+	if len(errs) != 0 {
+		t.Errorf("Expected errors to be empty, but is %q", errs)
+	}
+}
+
+// TestRemoveOldImagesDryRun verifies that a dry-run task never calls
+// BatchRemoveImages, but still produces a CleanupReport describing exactly
+// what a real run would have removed.
+func TestRemoveOldImagesDryRun(t *testing.T) {
+	namespace, repoName, imageDigest := "namespace", "repo", "image-digest"
+	kubeClient := &mockKubeClient{
+		t: t,
+
+		expectedNamespace: []string{namespace},
+		listAllPodsResult: []*v1.Pod{
+			{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{
+							Image: "id.dkr.ecr.region.amazonaws.com/repo:tag-1",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	registryClient := &mockRegistryClient{
+		t: t,
+
+		expectedRepositoryNames: []string{repoName},
+		listRepositoriesResult: []*Repository{
+			{Name: repoName},
+		},
+
+		expectedImagesRepositoryName: repoName,
+		listImagesResult: []*Image{
+			{Digest: imageDigest, SizeInBytes: 1024},
+		},
+
+		assertBatchRemoveNotCalled: true,
+	}
+
+	task := &CleanupTask{
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendECR, Repositories: []string{repoName}, Client: registryClient},
+		},
+
+		// Would cause the image to be deleted if this weren't a dry run.
+		MaxImages: 0,
+		DryRun:    true,
+	}
+
+	report, errs := task.RemoveOldImages(kubeClient)
+
+	if len(errs) != 0 {
+		t.Errorf("Expected errors to be empty, but is %q", errs)
+	}
+
+	if len(report.Repositories) != 1 {
+		t.Fatalf("Expected report to contain 1 repository, but it contains %d", len(report.Repositories))
+	}
+
+	repoReport := report.Repositories[0]
+	if repoReport.Scanned != 1 {
+		t.Errorf("Expected Scanned to be 1, but was %d", repoReport.Scanned)
+	}
+	if repoReport.Eligible != 1 {
+		t.Errorf("Expected Eligible to be 1, but was %d", repoReport.Eligible)
+	}
+	if repoReport.Deleted != 0 {
+		t.Errorf("Expected Deleted to be 0 in a dry run, but was %d", repoReport.Deleted)
+	}
+	if repoReport.BytesReclaimed != 1024 {
+		t.Errorf("Expected BytesReclaimed to be 1024, but was %d", repoReport.BytesReclaimed)
+	}
+	if len(repoReport.Entries) != 1 || repoReport.Entries[0].Digest != imageDigest {
+		t.Errorf("Expected a single report entry for digest %v, but got %+v", imageDigest, repoReport.Entries)
+	}
+}
+
+// TestRemoveOldImagesAcrossBackends verifies that a single CleanupTask can
+// clean repositories across two different registry backends in one run,
+// removing each backend's unused image through that backend's own client,
+// while pods reference images on both backends.
+func TestRemoveOldImagesAcrossBackends(t *testing.T) {
+	namespace := "namespace"
+	ecrRepo, gcrRepo := "ecr-repo", "gcr-repo"
+	ecrDigest, gcrDigest := "ecr-digest", "gcr-digest"
+
+	kubeClient := &mockKubeClient{
+		t: t,
+
+		expectedNamespace: []string{namespace},
+		listAllPodsResult: []*v1.Pod{
+			{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{Image: "id.dkr.ecr.region.amazonaws.com/ecr-repo:keep"},
+						{Image: "gcr.io/my-project/gcr-repo:keep"},
+					},
+				},
+			},
+		},
+	}
+
+	ecrClient := &mockRegistryClient{
+		t:          t,
+		hostSuffix: ".dkr.ecr.region.amazonaws.com",
+
+		expectedRepositoryNames: []string{ecrRepo},
+		listRepositoriesResult:  []*Repository{{Name: ecrRepo}},
+
+		expectedImagesRepositoryName: ecrRepo,
+		listImagesResult: []*Image{
+			{Digest: ecrDigest, Tags: []string{"old"}},
+		},
+		expectedImagesToRemove: []*Image{
+			{Digest: ecrDigest, Tags: []string{"old"}},
+		},
+	}
+
+	gcrClient := &mockRegistryClient{
+		t:          t,
+		hostSuffix: "gcr.io",
+
+		expectedRepositoryNames: []string{gcrRepo},
+		listRepositoriesResult:  []*Repository{{Name: gcrRepo}},
+
+		expectedImagesRepositoryName: gcrRepo,
+		listImagesResult: []*Image{
+			{Digest: gcrDigest, Tags: []string{"old"}},
+		},
+		expectedImagesToRemove: []*Image{
+			{Digest: gcrDigest, Tags: []string{"old"}},
+		},
+	}
+
+	task := &CleanupTask{
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendECR, Repositories: []string{ecrRepo}, Client: ecrClient},
+			{Backend: BackendGCR, Repositories: []string{gcrRepo}, Client: gcrClient},
+		},
+		MaxImages: 0,
+	}
+
+	_, errs := task.RemoveOldImages(kubeClient)
+
+	if len(errs) != 0 {
+		t.Errorf("Expected errors to be empty, but is %q", errs)
+	}
+}
+
+// TestRemoveOldImagesProtectsScaledToZeroDeployment verifies that an image
+// used only by a Deployment's pod template, with no running pods, is not
+// eligible for removal when the task is configured to scan workloads.
+func TestRemoveOldImagesProtectsScaledToZeroDeployment(t *testing.T) {
+	namespace, repoName, imageDigest := "namespace", "repo", "image-digest"
+
+	kubeClient := &mockKubeClient{
+		t: t,
+
+		expectedNamespace: []string{namespace},
+		listAllPodsResult: []*v1.Pod{},
+	}
+
+	workloadClient := &mockWorkloadClient{
+		deployments: []*appsv1beta1.Deployment{
+			{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "scaled-to-zero"},
+				Spec: appsv1beta1.DeploymentSpec{
+					Template: v1.PodTemplateSpec{
+						Spec: v1.PodSpec{
+							Containers: []v1.Container{
+								{Image: "id.dkr.ecr.region.amazonaws.com/repo:tag-1"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	registryClient := &mockRegistryClient{
 		t: t,
 
 		expectedRepositoryNames: []string{repoName},
-		listRepositoriesResult: []*ecr.Repository{
+		listRepositoriesResult: []*Repository{
+			{Name: repoName},
+		},
+
+		expectedImagesRepositoryName: repoName,
+		listImagesResult: []*Image{
+			{Digest: imageDigest, Tags: []string{"tag-1"}},
+		},
+
+		// No images expected to be removed: the scaled-to-zero Deployment
+		// still protects its image even though it has no running pods.
+	}
+
+	task := &CleanupTask{
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendECR, Repositories: []string{repoName}, Client: registryClient},
+		},
+		Workloads: &WorkloadImageCollector{Client: workloadClient},
+		MaxImages: 0,
+	}
+
+	_, errs := task.RemoveOldImages(kubeClient)
+
+	if len(errs) != 0 {
+		t.Errorf("Expected errors to be empty, but is %q", errs)
+	}
+}
+
+// TestRemoveOldImagesAppliesPullSecretAuth verifies that a registry
+// credential decoded from a workload's imagePullSecret is applied to the
+// CredentialedClient of the target whose RegistryHost it matches.
+func TestRemoveOldImagesAppliesPullSecretAuth(t *testing.T) {
+	namespace, repoName := "namespace", "repo"
+
+	kubeClient := &mockKubeClient{
+		t: t,
+
+		expectedNamespace: []string{namespace},
+		listAllPodsResult: []*v1.Pod{},
+	}
+
+	workloadClient := &mockWorkloadClient{
+		deployments: []*appsv1beta1.Deployment{
 			{
-				RepositoryName: &repoName,
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "app"},
+				Spec: appsv1beta1.DeploymentSpec{
+					Template: v1.PodTemplateSpec{
+						Spec: v1.PodSpec{
+							Containers:       []v1.Container{{Image: "registry.example.com/repo:tag-1"}},
+							ImagePullSecrets: []v1.LocalObjectReference{{Name: "my-pull-secret"}},
+						},
+					},
+				},
 			},
 		},
+		pullSecretResult: []byte(`{"auths":{"registry.example.com":{"username":"user","password":"pass"}}}`),
+	}
+
+	registryClient := &mockRegistryClient{
+		t: t,
+
+		hostSuffix:   "registry.example.com",
+		registryHost: "registry.example.com",
+
+		expectedRepositoryNames: []string{repoName},
+		listRepositoriesResult: []*Repository{
+			{Name: repoName},
+		},
 
 		expectedImagesRepositoryName: repoName,
-		listImagesResult: []*ecr.ImageDetail{
+		listImagesResult:             []*Image{},
+	}
+
+	task := &CleanupTask{
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendHarbor, Repositories: []string{repoName}, Client: registryClient},
+		},
+		Workloads: &WorkloadImageCollector{Client: workloadClient},
+		MaxImages: 0,
+	}
+
+	_, errs := task.RemoveOldImages(kubeClient)
+
+	if len(errs) != 0 {
+		t.Errorf("Expected errors to be empty, but is %q", errs)
+	}
+
+	if len(registryClient.authenticateCalls) != 1 {
+		t.Fatalf("Expected Authenticate to be called once, but was called %d times", len(registryClient.authenticateCalls))
+	}
+
+	got := registryClient.authenticateCalls[0]
+	if got.Username != "user" || got.Password != "pass" {
+		t.Errorf("Expected Authenticate to be called with user/pass, but got %+v", got)
+	}
+}
+
+func TestParseFilters(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []string
+		want    *RetentionPolicy
+		wantErr bool
+	}{
+		{
+			name:    "until duration",
+			filters: []string{"until=240h"},
+			want:    &RetentionPolicy{MaxAge: 240 * time.Hour, KeepLabels: map[string]string{}},
+		},
+		{
+			name:    "label",
+			filters: []string{"label=env=prod"},
+			want:    &RetentionPolicy{KeepLabels: map[string]string{"env": "prod"}},
+		},
+		{
+			name:    "reference glob",
+			filters: []string{"reference=myrepo:v1.*"},
+			want:    &RetentionPolicy{KeepTags: []string{"myrepo:v1.*"}, KeepLabels: map[string]string{}},
+		},
+		{
+			name:    "dangling",
+			filters: []string{"dangling=true"},
+			want:    &RetentionPolicy{KeepLabels: map[string]string{}},
+		},
+		{
+			name:    "unknown filter",
+			filters: []string{"bogus=true"},
+			wantErr: true,
+		},
+		{
+			name:    "malformed filter",
+			filters: []string{"notakeyvalue"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilters(tt.filters)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Expected an error, but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected no error, but got %s", err)
+			}
+
+			if got.MaxAge != tt.want.MaxAge {
+				t.Errorf("Expected MaxAge to be %v, but was %v", tt.want.MaxAge, got.MaxAge)
+			}
+
+			if len(got.KeepTags) != len(tt.want.KeepTags) {
+				t.Errorf("Expected KeepTags to be %v, but was %v", tt.want.KeepTags, got.KeepTags)
+			}
+
+			for k, v := range tt.want.KeepLabels {
+				if got.KeepLabels[k] != v {
+					t.Errorf("Expected label %s to be %v, but was %v", k, v, got.KeepLabels[k])
+				}
+			}
+		})
+	}
+}
+
+func TestRetentionPolicyKeeps(t *testing.T) {
+	digest := "image-digest"
+	recentPush := time.Now().Add(-time.Hour)
+	oldPush := time.Now().Add(-30 * 24 * time.Hour)
+
+	tests := []struct {
+		name   string
+		repo   string
+		policy *RetentionPolicy
+		image  *Image
+		want   bool
+	}{
+		{
+			name:   "within max age is kept",
+			policy: &RetentionPolicy{MaxAge: 24 * time.Hour},
+			image:  &Image{Digest: digest, PushedAt: recentPush},
+			want:   true,
+		},
+		{
+			name:   "older than max age is not kept",
+			policy: &RetentionPolicy{MaxAge: 24 * time.Hour},
+			image:  &Image{Digest: digest, PushedAt: oldPush},
+			want:   false,
+		},
+		{
+			name:   "matching tag glob is kept",
+			policy: &RetentionPolicy{KeepTags: []string{"v1.*"}},
+			image:  &Image{Digest: digest, PushedAt: oldPush, Tags: []string{"v1.2"}},
+			want:   true,
+		},
+		{
+			name:   "non-matching tag glob is not kept",
+			policy: &RetentionPolicy{KeepTags: []string{"v1.*"}},
+			image:  &Image{Digest: digest, PushedAt: oldPush, Tags: []string{"v0.1"}},
+			want:   false,
+		},
+		{
+			name:   "repo-prefixed reference glob is kept",
+			repo:   "myrepo",
+			policy: &RetentionPolicy{KeepTags: []string{"myrepo:v1.*"}},
+			image:  &Image{Digest: digest, PushedAt: oldPush, Tags: []string{"v1.2"}},
+			want:   true,
+		},
+		{
+			name:   "repo-prefixed reference glob does not match a different repo",
+			repo:   "otherrepo",
+			policy: &RetentionPolicy{KeepTags: []string{"myrepo:v1.*"}},
+			image:  &Image{Digest: digest, PushedAt: oldPush, Tags: []string{"v1.2"}},
+			want:   false,
+		},
+		{
+			name:   "matching label is kept",
+			policy: &RetentionPolicy{KeepLabels: map[string]string{"env": "prod"}},
+			image:  &Image{Digest: digest, PushedAt: oldPush, Tags: []string{"env=prod"}},
+			want:   true,
+		},
+		{
+			name:   "dangling policy keeps a tagged image",
+			policy: &RetentionPolicy{Dangling: true},
+			image:  &Image{Digest: digest, PushedAt: oldPush, Tags: []string{"v1.2"}},
+			want:   true,
+		},
+		{
+			name:   "dangling policy does not keep an untagged image",
+			policy: &RetentionPolicy{Dangling: true},
+			image:  &Image{Digest: digest, PushedAt: oldPush},
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.keeps(tt.repo, tt.image); got != tt.want {
+				t.Errorf("Expected keeps() to be %v, but was %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRemoveOldImagesWithRetentionPolicy(t *testing.T) {
+	namespace, repoName := "namespace", "repo"
+	oldPush := time.Now().Add(-30 * 24 * time.Hour)
+	keptDigest, removedDigest := "kept-digest", "removed-digest"
+
+	kubeClient := &mockKubeClient{
+		t: t,
+
+		expectedNamespace: []string{namespace},
+		listAllPodsResult: []*v1.Pod{},
+	}
+
+	images := []*Image{
+		{Digest: keptDigest, PushedAt: oldPush, Tags: []string{"v1.2"}},
+		{Digest: removedDigest, PushedAt: oldPush, Tags: []string{"v0.1"}},
+	}
+
+	registryClient := &mockRegistryClient{
+		t: t,
+
+		expectedRepositoryNames: []string{repoName},
+		listRepositoriesResult: []*Repository{
+			{Name: repoName},
+		},
+
+		expectedImagesRepositoryName: repoName,
+		listImagesResult:             images,
+
+		expectedImagesToRemove: []*Image{
+			{Digest: removedDigest},
+		},
+	}
+
+	task := &CleanupTask{
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendECR, Repositories: []string{repoName}, Client: registryClient},
+		},
+		Retention: &RetentionPolicy{
+			KeepTags: []string{"v1.*"},
+		},
+	}
+
+	_, errs := task.RemoveOldImages(kubeClient)
+
+	if len(errs) != 0 {
+		t.Errorf("Expected errors to be empty, but is %q", errs)
+	}
+}
+
+// TestRemoveOldImagesChildInUseProtectsIndex verifies that a running pod
+// pulling one child manifest of a multi-arch index, by digest, protects
+// both that child and the index itself (and, transitively, its sibling
+// children) from removal.
+func TestRemoveOldImagesChildInUseProtectsIndex(t *testing.T) {
+	namespace, repoName := "namespace", "repo"
+	indexDigest, amd64Digest, arm64Digest := "index-digest", "amd64-digest", "arm64-digest"
+
+	kubeClient := &mockKubeClient{
+		t: t,
+
+		expectedNamespace: []string{namespace},
+		listAllPodsResult: []*v1.Pod{
 			{
-				ImageDigest: &imageDigest,
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{Image: "id.dkr.ecr.region.amazonaws.com/repo@" + arm64Digest},
+					},
+				},
 			},
 		},
+	}
+
+	registryClient := &mockRegistryClient{
+		t: t,
 
-		expectedImagesToRemove: []*ecr.ImageDetail{
+		expectedRepositoryNames: []string{repoName},
+		listRepositoriesResult: []*Repository{
+			{Name: repoName},
+		},
+
+		expectedImagesRepositoryName: repoName,
+		listImagesResult: []*Image{
+			{Digest: indexDigest, Tags: []string{"multiarch"}},
+			{Digest: amd64Digest},
+			{Digest: arm64Digest},
+		},
+
+		getManifestResult: map[string][]string{
+			indexDigest: {amd64Digest, arm64Digest},
+		},
+
+		// No images expected to be removed: the index and both of its
+		// children are protected as a single multi-arch family.
+	}
+
+	task := &CleanupTask{
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendECR, Repositories: []string{repoName}, Client: registryClient},
+		},
+		MaxImages: 0,
+	}
+
+	_, errs := task.RemoveOldImages(kubeClient)
+
+	if len(errs) != 0 {
+		t.Errorf("Expected errors to be empty, but is %q", errs)
+	}
+}
+
+// TestRemoveOldImagesIndexInUseProtectsChildren verifies that a running
+// pod pulling a multi-arch index by tag protects every child manifest of
+// that index from removal, even though the children are individually
+// untagged and otherwise unreferenced.
+func TestRemoveOldImagesIndexInUseProtectsChildren(t *testing.T) {
+	namespace, repoName := "namespace", "repo"
+	indexDigest, amd64Digest, arm64Digest := "index-digest", "amd64-digest", "arm64-digest"
+
+	kubeClient := &mockKubeClient{
+		t: t,
+
+		expectedNamespace: []string{namespace},
+		listAllPodsResult: []*v1.Pod{
 			{
-				ImageDigest: &imageDigest,
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{
+						{Image: "id.dkr.ecr.region.amazonaws.com/repo:multiarch"},
+					},
+				},
 			},
 		},
 	}
 
+	registryClient := &mockRegistryClient{
+		t: t,
+
+		expectedRepositoryNames: []string{repoName},
+		listRepositoriesResult: []*Repository{
+			{Name: repoName},
+		},
+
+		expectedImagesRepositoryName: repoName,
+		listImagesResult: []*Image{
+			{Digest: indexDigest, Tags: []string{"multiarch"}},
+			{Digest: amd64Digest},
+			{Digest: arm64Digest},
+		},
+
+		getManifestResult: map[string][]string{
+			indexDigest: {amd64Digest, arm64Digest},
+		},
+
+		// No images expected to be removed: the in-use index protects
+		// both of its otherwise-unreferenced children.
+	}
+
 	task := &CleanupTask{
-		KubeNamespaces:  []*string{&namespace},
-		EcrRepositories: []*string{&repoName},
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendECR, Repositories: []string{repoName}, Client: registryClient},
+		},
+		MaxImages: 0,
+	}
 
-		// Will cause the image to be deleted
+	_, errs := task.RemoveOldImages(kubeClient)
+
+	if len(errs) != 0 {
+		t.Errorf("Expected errors to be empty, but is %q", errs)
+	}
+}
+
+// TestRemoveOldImagesIndexKeptByRetentionProtectsChildren verifies that a
+// multi-arch index protected by a RetentionPolicy (rather than by pod
+// in-use status) also protects its untagged child manifests, so that a
+// "reference=" or "dangling=" rule doesn't leave the index's own children
+// to be deleted out from under it.
+func TestRemoveOldImagesIndexKeptByRetentionProtectsChildren(t *testing.T) {
+	namespace, repoName := "namespace", "repo"
+	indexDigest, amd64Digest, arm64Digest := "index-digest", "amd64-digest", "arm64-digest"
+
+	kubeClient := &mockKubeClient{
+		t: t,
+
+		expectedNamespace: []string{namespace},
+		listAllPodsResult: nil,
+	}
+
+	registryClient := &mockRegistryClient{
+		t: t,
+
+		expectedRepositoryNames: []string{repoName},
+		listRepositoriesResult: []*Repository{
+			{Name: repoName},
+		},
+
+		expectedImagesRepositoryName: repoName,
+		listImagesResult: []*Image{
+			{Digest: indexDigest, Tags: []string{"keep-me"}},
+			{Digest: amd64Digest},
+			{Digest: arm64Digest},
+		},
+
+		getManifestResult: map[string][]string{
+			indexDigest: {amd64Digest, arm64Digest},
+		},
+
+		// No images expected to be removed: the retention-kept index
+		// protects both of its otherwise-unreferenced children.
+	}
+
+	task := &CleanupTask{
+		KubeNamespaces: []*string{&namespace},
+		Targets: []*CleanupTarget{
+			{Backend: BackendECR, Repositories: []string{repoName}, Client: registryClient},
+		},
 		MaxImages: 0,
+		Retention: &RetentionPolicy{KeepTags: []string{"keep-me"}},
 	}
 
-	errs := task.RemoveOldImages(kubeClient, ecrClient)
+	_, errs := task.RemoveOldImages(kubeClient)
 
 	if len(errs) != 0 {
 		t.Errorf("Expected errors to be empty, but is %q", errs)
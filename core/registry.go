@@ -0,0 +1,48 @@
+package core
+
+import "time"
+
+// Repository identifies a single image repository within a registry
+// backend, independent of which cloud provider or on-prem registry hosts
+// it.
+type Repository struct {
+	Name string
+}
+
+// Image describes a single image, or manifest, stored in a repository,
+// normalized across registry backends. Backends that cannot report a given
+// field (for example, push time on a plain Docker Registry v2 API) leave it
+// at its zero value.
+type Image struct {
+	Digest      string
+	Tags        []string
+	PushedAt    time.Time
+	SizeInBytes int64
+}
+
+// RegistryClient abstracts the registry API operations needed to list and
+// remove images, regardless of which cloud or on-prem registry backend is
+// in use. Each backend under pkg/registry implements this interface and is
+// constructed by pkg/registry.New from a CleanupTarget.
+type RegistryClient interface {
+	// ListRepositories returns the repositories matching the given names.
+	ListRepositories(repositoryNames []string) ([]*Repository, error)
+
+	// ListImages returns every image stored in the named repository.
+	ListImages(repositoryName string) ([]*Image, error)
+
+	// BatchRemoveImages deletes the given images from a repository.
+	BatchRemoveImages(repositoryName string, images []*Image) error
+
+	// ParseImageRef parses a fully-qualified image reference as it appears
+	// in a pod spec (e.g. "<host>/<repo>:<tag>" or "<host>/<repo>@<digest>").
+	// It reports ok=false when the reference's host does not belong to
+	// this backend, so that callers can dispatch a reference to the
+	// right backend when a CleanupTask spans several of them.
+	ParseImageRef(image string) (repo, tag, digest string, ok bool)
+
+	// ResolveDigest resolves a tag to the manifest digest it currently
+	// points at, so that in-use detection stays accurate even after a tag
+	// such as "latest" is repointed following a pod's pull of it.
+	ResolveDigest(repository, tag string) (digest string, ok bool)
+}
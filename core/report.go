@@ -0,0 +1,53 @@
+package core
+
+import "time"
+
+// CleanupReport summarizes the outcome of a single CleanupTask run, broken
+// down by repository. In dry-run mode it describes exactly what a real run
+// would have deleted, without anything having actually been removed.
+type CleanupReport struct {
+	Repositories []*RepositoryReport
+	GeneratedAt  time.Time
+
+	// TriggerSource identifies what started the run this report describes.
+	TriggerSource TriggerSource
+}
+
+// RepositoryReport summarizes what a CleanupTask run found and did in a
+// single repository.
+type RepositoryReport struct {
+	Repository string
+
+	// Scanned is the total number of images present in the repository.
+	Scanned int
+
+	// InUse is the number of those images currently referenced by a
+	// running pod, a protected workload, or (via manifest propagation) an
+	// in-use multi-arch manifest list.
+	InUse int
+
+	// Eligible is the number of images that were safe to remove under the
+	// task's retention rules, regardless of whether they were actually
+	// deleted.
+	Eligible int
+
+	// Deleted is the number of eligible images actually removed. It is
+	// always 0 in dry-run mode.
+	Deleted int
+
+	// BytesReclaimed is the total size of the eligible images, whether or
+	// not they were actually deleted.
+	BytesReclaimed int64
+
+	// Entries describes each eligible image individually.
+	Entries []ReportEntry
+}
+
+// ReportEntry describes a single image found eligible for removal.
+type ReportEntry struct {
+	Repository string
+	Digest     string
+	Tags       []string
+	PushedAt   time.Time
+	Reason     string
+}
@@ -0,0 +1,158 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy describes which images in a repository are protected from
+// removal, independent of the simple "keep the N newest" rule. It mirrors
+// the filter-expression syntax of `podman image prune --filter`.
+type RetentionPolicy struct {
+	// MaxAge keeps any image pushed more recently than this duration ago.
+	MaxAge time.Duration
+
+	// KeepTags keeps any image with a tag matching one of these glob
+	// patterns (e.g. "v1.*", "latest").
+	KeepTags []string
+
+	// KeepLabels keeps any image carrying all of these label key/value
+	// pairs. The ECR API does not surface OCI labels directly, so labels
+	// are read from tags of the form "key=value", the convention used by
+	// the label= filter below.
+	KeepLabels map[string]string
+
+	// KeepLastN additionally keeps the N most recently pushed images,
+	// regardless of the other rules above, mirroring CleanupTask.MaxImages.
+	KeepLastN int
+
+	// Dangling, when set, protects every tagged image, mirroring
+	// `dangling=true`'s podman/docker meaning: only untagged images are
+	// eligible for removal.
+	Dangling bool
+}
+
+// ParseFilters parses a list of `podman image prune --filter`-style filter
+// expressions into a RetentionPolicy. Supported filters are:
+//
+//	until=<duration|RFC3339 timestamp>  keep images younger than this
+//	label=<key>=<value>                 keep images carrying this label
+//	dangling=true                       untagged images are eligible for removal
+//	reference=<glob>                    keep images whose tag matches this glob
+//
+// An image is kept if it matches any one of the parsed rules.
+func ParseFilters(filters []string) (*RetentionPolicy, error) {
+	policy := &RetentionPolicy{KeepLabels: map[string]string{}}
+
+	for _, filter := range filters {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid filter %q: expected key=value", filter)
+		}
+
+		switch key {
+		case "until":
+			age, err := parseUntil(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter %q: %s", filter, err)
+			}
+			policy.MaxAge = age
+
+		case "label":
+			labelKey, labelValue, ok := strings.Cut(value, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid filter %q: expected label=key=value", filter)
+			}
+			policy.KeepLabels[labelKey] = labelValue
+
+		case "dangling":
+			if value != "true" {
+				return nil, fmt.Errorf("invalid filter %q: dangling only supports \"true\"", filter)
+			}
+			policy.Dangling = true
+
+		case "reference":
+			policy.KeepTags = append(policy.KeepTags, value)
+
+		default:
+			return nil, fmt.Errorf("invalid filter %q: unknown filter key %q", filter, key)
+		}
+	}
+
+	return policy, nil
+}
+
+// parseUntil accepts either a Go duration ("240h", meaning "this long ago")
+// or an RFC3339 timestamp, and returns the equivalent "keep younger than"
+// duration relative to now.
+func parseUntil(value string) (time.Duration, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return d, nil
+	}
+
+	ts, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("must be a duration or RFC3339 timestamp: %s", err)
+	}
+
+	return time.Since(ts), nil
+}
+
+// keeps reports whether the given image, in repoName, is protected from
+// removal by the policy. KeepLastN is not considered here: it is applied
+// separately across the whole candidate set once the age, tag, and label
+// rules have run.
+func (p *RetentionPolicy) keeps(repoName string, image *Image) bool {
+	if p == nil {
+		return false
+	}
+
+	if p.MaxAge > 0 && !image.PushedAt.IsZero() && time.Since(image.PushedAt) < p.MaxAge {
+		return true
+	}
+
+	if p.Dangling && len(image.Tags) > 0 {
+		return true
+	}
+
+	for _, tag := range image.Tags {
+		for _, pattern := range p.KeepTags {
+			// reference=<glob> is documented as matching either a bare
+			// tag glob ("v1.*") or a "repo:tag" glob ("myrepo:v1.*"), so
+			// try both forms.
+			if ok, _ := filepath.Match(pattern, tag); ok {
+				return true
+			}
+			if ok, _ := filepath.Match(pattern, repoName+":"+tag); ok {
+				return true
+			}
+		}
+	}
+
+	if len(p.KeepLabels) > 0 && imageHasLabels(image, p.KeepLabels) {
+		return true
+	}
+
+	return false
+}
+
+// imageHasLabels reports whether image carries all of the given labels,
+// read from any "key=value" formatted tag on the image.
+func imageHasLabels(image *Image, want map[string]string) bool {
+	has := map[string]string{}
+	for _, tag := range image.Tags {
+		if k, v, ok := strings.Cut(tag, "="); ok {
+			has[k] = v
+		}
+	}
+
+	for k, v := range want {
+		if has[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
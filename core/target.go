@@ -0,0 +1,37 @@
+package core
+
+// Backend identifies which registry implementation a CleanupTarget uses.
+type Backend string
+
+const (
+	BackendECR       Backend = "ecr"
+	BackendGCR       Backend = "gcr"
+	BackendACR       Backend = "acr"
+	BackendDockerHub Backend = "dockerhub"
+	BackendHarbor    Backend = "harbor"
+)
+
+// CleanupTarget describes a single registry to clean as part of a
+// CleanupTask: which backend it is, which repositories within it to
+// inspect, and the backend-specific location needed to reach it.
+type CleanupTarget struct {
+	Backend      Backend
+	Repositories []string
+
+	// Region is the AWS region of an ecr target.
+	Region string
+
+	// Project is the GCP project of a gcr target.
+	Project string
+
+	// Registry is the registry hostname of an acr, dockerhub, or harbor
+	// target (e.g. "myregistry.azurecr.io", "myorg.harbor.example.com"),
+	// or, for a gcr target, overrides the default "gcr.io" host to reach a
+	// regional mirror (e.g. "eu.gcr.io") or an Artifact Registry host
+	// (e.g. "us-docker.pkg.dev").
+	Registry string
+
+	// Client is the RegistryClient used to talk to this target, built by
+	// pkg/registry.New from the fields above.
+	Client RegistryClient
+}
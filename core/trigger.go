@@ -0,0 +1,20 @@
+package core
+
+// TriggerSource identifies what caused a CleanupTask run, so that reports
+// and metrics can distinguish a routine sweep from a run reacting to a
+// registry event or a run started by hand.
+type TriggerSource string
+
+const (
+	// TriggerSchedule is a run started by the periodic sweeper. It is the
+	// default when a CleanupTask's TriggerSource is left unset.
+	TriggerSchedule TriggerSource = "schedule"
+
+	// TriggerWebhook is a run started in reaction to an incoming registry
+	// event, scoped to the repository the event named.
+	TriggerWebhook TriggerSource = "webhook"
+
+	// TriggerManual is a run started directly, such as from a CLI
+	// invocation outside of the controller's own sweeper.
+	TriggerManual TriggerSource = "manual"
+)
@@ -0,0 +1,209 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/client-go/pkg/api/v1"
+	appsv1beta1 "k8s.io/client-go/pkg/apis/apps/v1beta1"
+	batchv1 "k8s.io/client-go/pkg/apis/batch/v1"
+	batchv2alpha1 "k8s.io/client-go/pkg/apis/batch/v2alpha1"
+	extensionsv1beta1 "k8s.io/client-go/pkg/apis/extensions/v1beta1"
+)
+
+// WorkloadClient abstracts the Kubernetes APIs used to discover images
+// referenced by workload controllers, as opposed to currently running
+// pods. A Deployment scaled to zero, a suspended CronJob, or a paused
+// rollout has no running pods, but its images are still in use and must
+// not be pruned.
+type WorkloadClient interface {
+	ListAllDeployments(namespaces []*string) ([]*appsv1beta1.Deployment, error)
+	ListAllStatefulSets(namespaces []*string) ([]*appsv1beta1.StatefulSet, error)
+	ListAllDaemonSets(namespaces []*string) ([]*extensionsv1beta1.DaemonSet, error)
+	ListAllReplicaSets(namespaces []*string) ([]*extensionsv1beta1.ReplicaSet, error)
+	ListAllJobs(namespaces []*string) ([]*batchv1.Job, error)
+	ListAllCronJobs(namespaces []*string) ([]*batchv2alpha1.CronJob, error)
+}
+
+// RolloutClient is implemented by a WorkloadClient that can also list Argo
+// Rollouts. It is optional: a cluster without the Argo Rollouts CRD
+// installed simply has a WorkloadClient that doesn't implement it, and
+// Collect skips rollouts entirely.
+type RolloutClient interface {
+	ListAllRollouts(namespaces []*string) ([]*Rollout, error)
+}
+
+// Rollout is a normalized view of the fields of an argoproj.io/v1alpha1
+// Rollout needed for image collection, so that core does not take a hard
+// dependency on the Argo Rollouts API types.
+type Rollout struct {
+	Namespace string
+	Name      string
+	Spec      v1.PodSpec
+}
+
+// PullSecretClient is implemented by a WorkloadClient that can resolve the
+// image pull secrets referenced by a pod spec to their decoded
+// .dockerconfigjson contents. It is optional: a WorkloadClient that
+// doesn't implement it simply skips pull secret resolution.
+type PullSecretClient interface {
+	// GetImagePullSecret returns the raw ".dockerconfigjson" contents of
+	// the named kubernetes.io/dockerconfigjson secret.
+	GetImagePullSecret(namespace, name string) ([]byte, error)
+}
+
+// RegistryAuth is a single entry of a decoded .dockerconfigjson, as stored
+// by an imagePullSecret.
+type RegistryAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+// CredentialedClient is implemented by a RegistryClient that can accept
+// registry credentials discovered at runtime, such as those decoded from a
+// cluster's imagePullSecrets. It is optional: a RegistryClient that doesn't
+// implement it (such as ecr.Client, which authenticates via ambient AWS
+// credentials) is assumed to already be fully configured by
+// pkg/registry.New, and pull secret resolution is simply skipped for it.
+type CredentialedClient interface {
+	// RegistryHost returns the hostname this client talks to, so that a
+	// decoded imagePullSecret (keyed by hostname) can be matched to it.
+	RegistryHost() string
+
+	// Authenticate configures the client to use auth when talking to its
+	// registry, overriding any statically configured credentials.
+	Authenticate(auth RegistryAuth)
+}
+
+type dockerConfigJSON struct {
+	Auths map[string]RegistryAuth `json:"auths"`
+}
+
+// pullSecretRef identifies a single imagePullSecret reference by the
+// namespace of the pod template that referenced it and the secret's name.
+type pullSecretRef struct {
+	namespace string
+	name      string
+}
+
+// WorkloadImageCollector gathers the image references used by workload pod
+// templates across a cluster, independent of whether those workloads
+// currently have any running pods, and resolves the image pull secrets
+// those templates reference.
+type WorkloadImageCollector struct {
+	Client WorkloadClient
+}
+
+// Collect returns the image references used by every workload pod template
+// in the given namespaces, along with the registry credentials found in
+// any imagePullSecrets those templates reference (keyed by registry
+// hostname). Pull secrets are resolved on a best-effort basis: a fetch or
+// decode failure for one secret does not fail the whole collection.
+func (c *WorkloadImageCollector) Collect(namespaces []*string) ([]string, map[string]RegistryAuth, error) {
+	var images []string
+	secretRefs := map[pullSecretRef]bool{}
+
+	addSpec := func(namespace string, spec v1.PodSpec) {
+		images = append(images, podSpecImages(spec)...)
+		for _, ref := range spec.ImagePullSecrets {
+			secretRefs[pullSecretRef{namespace: namespace, name: ref.Name}] = true
+		}
+	}
+
+	deployments, err := c.Client.ListAllDeployments(namespaces)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing deployments: %s", err)
+	}
+	for _, d := range deployments {
+		addSpec(d.Namespace, d.Spec.Template.Spec)
+	}
+
+	statefulSets, err := c.Client.ListAllStatefulSets(namespaces)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing statefulsets: %s", err)
+	}
+	for _, s := range statefulSets {
+		addSpec(s.Namespace, s.Spec.Template.Spec)
+	}
+
+	daemonSets, err := c.Client.ListAllDaemonSets(namespaces)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing daemonsets: %s", err)
+	}
+	for _, d := range daemonSets {
+		addSpec(d.Namespace, d.Spec.Template.Spec)
+	}
+
+	replicaSets, err := c.Client.ListAllReplicaSets(namespaces)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing replicasets: %s", err)
+	}
+	for _, r := range replicaSets {
+		addSpec(r.Namespace, r.Spec.Template.Spec)
+	}
+
+	jobs, err := c.Client.ListAllJobs(namespaces)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing jobs: %s", err)
+	}
+	for _, j := range jobs {
+		addSpec(j.Namespace, j.Spec.Template.Spec)
+	}
+
+	cronJobs, err := c.Client.ListAllCronJobs(namespaces)
+	if err != nil {
+		return nil, nil, fmt.Errorf("listing cronjobs: %s", err)
+	}
+	for _, cj := range cronJobs {
+		addSpec(cj.Namespace, cj.Spec.JobTemplate.Spec.Template.Spec)
+	}
+
+	if rolloutClient, ok := c.Client.(RolloutClient); ok {
+		rollouts, err := rolloutClient.ListAllRollouts(namespaces)
+		if err != nil {
+			return nil, nil, fmt.Errorf("listing rollouts: %s", err)
+		}
+		for _, r := range rollouts {
+			addSpec(r.Namespace, r.Spec)
+		}
+	}
+
+	pullSecrets := map[string]RegistryAuth{}
+
+	if secretClient, ok := c.Client.(PullSecretClient); ok {
+		for ref := range secretRefs {
+			raw, err := secretClient.GetImagePullSecret(ref.namespace, ref.name)
+			if err != nil {
+				continue
+			}
+
+			var cfg dockerConfigJSON
+			if err := json.Unmarshal(raw, &cfg); err != nil {
+				continue
+			}
+
+			for host, auth := range cfg.Auths {
+				pullSecrets[host] = auth
+			}
+		}
+	}
+
+	return images, pullSecrets, nil
+}
+
+// podSpecImages returns every image referenced by a pod spec's containers
+// and init containers. This client-go generation's v1.PodSpec has no
+// EphemeralContainers field, so ephemeral containers can't be inspected.
+func podSpecImages(spec v1.PodSpec) []string {
+	var images []string
+
+	for _, c := range spec.Containers {
+		images = append(images, c.Image)
+	}
+	for _, c := range spec.InitContainers {
+		images = append(images, c.Image)
+	}
+
+	return images
+}
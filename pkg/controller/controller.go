@@ -0,0 +1,172 @@
+// Package controller runs a CleanupTask on a schedule and, optionally, in
+// reaction to registry push events delivered to a webhook listener.
+package controller
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/core"
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/pkg/metrics"
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/pkg/webhook"
+)
+
+// Config configures a controller run.
+type Config struct {
+	// Task is the base CleanupTask swept on SweepInterval. A webhook-
+	// triggered run uses a copy of Task scoped to just the repository the
+	// event named.
+	Task *core.CleanupTask
+
+	// KubeClient is passed to every RemoveOldImages call, scheduled or
+	// webhook-triggered alike.
+	KubeClient core.KubeClient
+
+	// Recorder, if set, records the report and errors of every run.
+	Recorder *metrics.Recorder
+
+	// MetricsAddr, when set alongside Recorder, serves Recorder's /metrics
+	// and /report endpoints on this address (e.g. ":9090").
+	MetricsAddr string
+
+	// SweepInterval is how often Task is run in full. It is required.
+	SweepInterval time.Duration
+
+	// Webhook, when set, starts a plain HTTP listener for registry push
+	// events and runs a scoped cleanup for the affected repository as
+	// soon as a debounced burst of events settles, rather than waiting
+	// for the next sweep.
+	Webhook *webhook.Config
+}
+
+// RunController runs the periodic sweep and, if configured, the webhook
+// listener concurrently, until ctx is canceled or one of them fails.
+func RunController(ctx context.Context, config Config) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runSweeper(runCtx, config)
+	}()
+
+	if config.Webhook != nil {
+		server := webhook.NewServer(*config.Webhook, func(repository string) {
+			runScoped(config, repository)
+		})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.ListenAndServe(runCtx, *config.Webhook); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+
+	if config.Recorder != nil && config.MetricsAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := config.Recorder.ListenAndServe(runCtx, config.MetricsAddr); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	return <-errs
+}
+
+// runSweeper runs config.Task in full immediately, then again every
+// SweepInterval, until ctx is canceled.
+func runSweeper(ctx context.Context, config Config) {
+	task := *config.Task
+	task.TriggerSource = core.TriggerSchedule
+
+	run := func() {
+		report, errs := task.RemoveOldImages(config.KubeClient)
+		recordRun(config.Recorder, report, errs)
+	}
+
+	run()
+
+	ticker := time.NewTicker(config.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run()
+		}
+	}
+}
+
+// runScoped runs config.Task against just repository, in reaction to a
+// webhook-reported push event.
+func runScoped(config Config, repository string) {
+	task := scopedTask(config.Task, repository)
+
+	report, errs := task.RemoveOldImages(config.KubeClient)
+	recordRun(config.Recorder, report, errs)
+}
+
+// scopedTask returns a copy of task limited to the targets that own
+// repository, each restricted to just that repository, and marked as
+// webhook-triggered.
+func scopedTask(task *core.CleanupTask, repository string) *core.CleanupTask {
+	scoped := *task
+	scoped.TriggerSource = core.TriggerWebhook
+
+	var targets []*core.CleanupTarget
+	for _, target := range task.Targets {
+		if !targetOwnsRepository(target, repository) {
+			continue
+		}
+
+		scopedTarget := *target
+		scopedTarget.Repositories = []string{repository}
+		targets = append(targets, &scopedTarget)
+	}
+	scoped.Targets = targets
+
+	return &scoped
+}
+
+// targetOwnsRepository reports whether target is scoped to repository, or
+// to every repository in its registry (an empty Repositories list).
+func targetOwnsRepository(target *core.CleanupTarget, repository string) bool {
+	if len(target.Repositories) == 0 {
+		return true
+	}
+
+	for _, name := range target.Repositories {
+		if name == repository {
+			return true
+		}
+	}
+
+	return false
+}
+
+func recordRun(recorder *metrics.Recorder, report *core.CleanupReport, errs []error) {
+	if recorder != nil {
+		recorder.Record(report, errs)
+	}
+
+	for _, err := range errs {
+		log.Printf("cleanup error: %s", err)
+	}
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/core"
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/pkg/webhook"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+type stubKubeClient struct{}
+
+func (stubKubeClient) ListAllPods(namespaces []*string) ([]*v1.Pod, error) {
+	return nil, nil
+}
+
+// recordingRegistryClient is a core.RegistryClient that records which
+// repository BatchRemoveImages was called for, so a test can assert that a
+// webhook event produced exactly one scoped cleanup run.
+type recordingRegistryClient struct {
+	mu      sync.Mutex
+	removed []string
+}
+
+func (c *recordingRegistryClient) ListRepositories(repositoryNames []string) ([]*core.Repository, error) {
+	repos := make([]*core.Repository, len(repositoryNames))
+	for i, name := range repositoryNames {
+		repos[i] = &core.Repository{Name: name}
+	}
+	return repos, nil
+}
+
+func (c *recordingRegistryClient) ListImages(repositoryName string) ([]*core.Image, error) {
+	return []*core.Image{{Digest: "sha256:unused"}}, nil
+}
+
+func (c *recordingRegistryClient) BatchRemoveImages(repositoryName string, images []*core.Image) error {
+	c.mu.Lock()
+	c.removed = append(c.removed, repositoryName)
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *recordingRegistryClient) ParseImageRef(image string) (repo, tag, digest string, ok bool) {
+	return "", "", "", false
+}
+
+func (c *recordingRegistryClient) ResolveDigest(repository, tag string) (string, bool) {
+	return "", false
+}
+
+func (c *recordingRegistryClient) removedRepositories() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string{}, c.removed...)
+}
+
+const testSecret = "test-secret"
+
+func sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TestWebhookTriggersScopedCleanup verifies that a synthetic ECR push event
+// posted to the controller's webhook listener results in exactly one
+// RemoveOldImages run, scoped to the repository the event named, without
+// waiting for the periodic sweep.
+func TestWebhookTriggersScopedCleanup(t *testing.T) {
+	registryClient := &recordingRegistryClient{}
+
+	task := &core.CleanupTask{
+		Targets: []*core.CleanupTarget{
+			{Backend: core.BackendECR, Client: registryClient},
+		},
+		MaxImages: 0,
+	}
+
+	dispatched := make(chan string, 1)
+	server := webhook.NewServer(webhook.Config{Secret: testSecret, DebounceWindow: time.Millisecond}, func(repository string) {
+		runScoped(Config{Task: task, KubeClient: stubKubeClient{}}, repository)
+		dispatched <- repository
+	})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	body := []byte(`{
+		"detail-type": "ECR Image Action",
+		"source": "aws.ecr",
+		"detail": {
+			"action-type": "PUSH",
+			"result": "SUCCESS",
+			"repository-name": "my-repo",
+			"image-tag": "latest",
+			"image-digest": "sha256:abc"
+		}
+	}`)
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(body))
+	req.Header.Set("X-Ecr-Cleanup-Signature", sign(body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("posting event: %s", err)
+	}
+	resp.Body.Close()
+
+	select {
+	case repository := <-dispatched:
+		if repository != "my-repo" {
+			t.Errorf("Expected dispatch for my-repo, but got %v", repository)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a dispatch within 1s, but got none")
+	}
+
+	removed := registryClient.removedRepositories()
+	if len(removed) != 1 || removed[0] != "my-repo" {
+		t.Errorf("Expected BatchRemoveImages to be called exactly once, for my-repo, but got %v", removed)
+	}
+}
+
+// TestScopedTaskLimitsTargetsToNamedRepository verifies that scopedTask
+// drops targets whose explicit repository list doesn't include the named
+// repository, and narrows a matching target's Repositories to just it.
+func TestScopedTaskLimitsTargetsToNamedRepository(t *testing.T) {
+	task := &core.CleanupTask{
+		Targets: []*core.CleanupTarget{
+			{Backend: core.BackendECR, Repositories: []string{"other-repo"}},
+			{Backend: core.BackendGCR, Repositories: []string{"my-repo", "other-repo"}},
+		},
+	}
+
+	scoped := scopedTask(task, "my-repo")
+
+	if len(scoped.Targets) != 1 {
+		t.Fatalf("Expected 1 target to own my-repo, but got %d", len(scoped.Targets))
+	}
+	if scoped.Targets[0].Backend != core.BackendGCR {
+		t.Errorf("Expected the matching target to be the GCR one, but got %v", scoped.Targets[0].Backend)
+	}
+	if len(scoped.Targets[0].Repositories) != 1 || scoped.Targets[0].Repositories[0] != "my-repo" {
+		t.Errorf("Expected Repositories to be narrowed to [my-repo], but got %v", scoped.Targets[0].Repositories)
+	}
+	if scoped.TriggerSource != core.TriggerWebhook {
+		t.Errorf("Expected TriggerSource to be %q, but got %q", core.TriggerWebhook, scoped.TriggerSource)
+	}
+}
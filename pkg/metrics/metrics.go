@@ -0,0 +1,140 @@
+// Package metrics exposes a CleanupTask run's core.CleanupReport as
+// Prometheus metrics on /metrics and as JSON on /report.
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/core"
+)
+
+// Recorder records the outcome of cleanup runs and exposes it both as
+// Prometheus metrics and as the JSON body of the most recent
+// core.CleanupReport.
+type Recorder struct {
+	registry *prometheus.Registry
+
+	imagesDeleted    *prometheus.CounterVec
+	bytesReclaimed   *prometheus.CounterVec
+	lastRunTimestamp *prometheus.GaugeVec
+	errorsTotal      *prometheus.CounterVec
+
+	mu         sync.RWMutex
+	lastReport *core.CleanupReport
+}
+
+// NewRecorder builds a Recorder with its own Prometheus registry, so that
+// running several CleanupTasks in the same process (one per backend, for
+// example) doesn't collide on metric registration.
+func NewRecorder() *Recorder {
+	r := &Recorder{
+		registry: prometheus.NewRegistry(),
+
+		imagesDeleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ecr_cleanup_images_deleted_total",
+			Help: "Total number of images deleted by the cleanup controller, by repository.",
+		}, []string{"repo"}),
+
+		bytesReclaimed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ecr_cleanup_bytes_reclaimed_total",
+			Help: "Total number of image bytes reclaimed by the cleanup controller, by repository.",
+		}, []string{"repo"}),
+
+		lastRunTimestamp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ecr_cleanup_last_run_timestamp",
+			Help: "Unix timestamp of the most recently completed cleanup run, by trigger source.",
+		}, []string{"trigger"}),
+
+		errorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ecr_cleanup_errors_total",
+			Help: "Total number of errors encountered by the cleanup controller, by repository and phase.",
+		}, []string{"repo", "phase"}),
+	}
+
+	r.registry.MustRegister(r.imagesDeleted, r.bytesReclaimed, r.lastRunTimestamp, r.errorsTotal)
+
+	return r
+}
+
+// Record updates the exposed metrics from the result of a CleanupTask run
+// and stores the report for the /report endpoint. A dry run never
+// increments the deletion or reclaimed-bytes counters, since
+// RepositoryReport.Deleted is always 0 in that case.
+func (r *Recorder) Record(report *core.CleanupReport, errs []error) {
+	r.mu.Lock()
+	r.lastReport = report
+	r.mu.Unlock()
+
+	for _, repo := range report.Repositories {
+		if repo.Deleted > 0 {
+			r.imagesDeleted.WithLabelValues(repo.Repository).Add(float64(repo.Deleted))
+			r.bytesReclaimed.WithLabelValues(repo.Repository).Add(float64(repo.BytesReclaimed))
+		}
+	}
+
+	for _, err := range errs {
+		if cleanupErr, ok := err.(*core.CleanupError); ok {
+			r.errorsTotal.WithLabelValues(cleanupErr.Repository, cleanupErr.Phase).Inc()
+			continue
+		}
+		r.errorsTotal.WithLabelValues("", "unknown").Inc()
+	}
+
+	r.lastRunTimestamp.WithLabelValues(string(report.TriggerSource)).Set(float64(time.Now().Unix()))
+}
+
+// MetricsHandler serves the recorder's metrics in the Prometheus exposition
+// format, for mounting at /metrics.
+func (r *Recorder) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(r.registry, promhttp.HandlerOpts{})
+}
+
+// ReportHandler serves the most recent CleanupReport as JSON, for mounting
+// at /report. It serves "{}" if no run has completed yet.
+func (r *Recorder) ReportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.RLock()
+		report := r.lastReport
+		r.mu.RUnlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if report == nil {
+			w.Write([]byte("{}"))
+			return
+		}
+
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			http.Error(w, "encoding report: "+err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// ListenAndServe serves the recorder's /metrics and /report endpoints on
+// addr until ctx is canceled, at which point it shuts down gracefully.
+func (r *Recorder) ListenAndServe(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.MetricsHandler())
+	mux.Handle("/report", r.ReportHandler())
+
+	httpServer := &http.Server{Addr: addr, Handler: mux}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return httpServer.Shutdown(context.Background())
+	case err := <-errs:
+		return err
+	}
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/core"
+)
+
+func TestMetricsHandlerServesRecordedCounters(t *testing.T) {
+	recorder := NewRecorder()
+	recorder.Record(&core.CleanupReport{
+		GeneratedAt:   time.Now(),
+		TriggerSource: core.TriggerSchedule,
+		Repositories: []*core.RepositoryReport{
+			{Repository: "my-repo", Deleted: 2, BytesReclaimed: 1024},
+		},
+	}, nil)
+
+	ts := httptest.NewServer(recorder.MetricsHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("GET /metrics: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, but got %d", resp.StatusCode)
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading /metrics: %s", err)
+	}
+
+	want := `ecr_cleanup_images_deleted_total{repo="my-repo"} 2`
+	if !strings.Contains(string(raw), want) {
+		t.Errorf("Expected the metrics output to contain %q, but got %v", want, string(raw))
+	}
+}
+
+func TestReportHandlerServesLastReportAsJSON(t *testing.T) {
+	recorder := NewRecorder()
+	recorder.Record(&core.CleanupReport{
+		GeneratedAt:   time.Now(),
+		TriggerSource: core.TriggerWebhook,
+		Repositories: []*core.RepositoryReport{
+			{Repository: "my-repo", Deleted: 1},
+		},
+	}, nil)
+
+	ts := httptest.NewServer(recorder.ReportHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("GET /report: %s", err)
+	}
+	defer resp.Body.Close()
+
+	buf := make([]byte, 4096)
+	n, _ := resp.Body.Read(buf)
+	body := string(buf[:n])
+
+	if !strings.Contains(body, "my-repo") {
+		t.Errorf("Expected the report JSON to contain the repository name, but got %v", body)
+	}
+}
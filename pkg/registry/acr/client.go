@@ -0,0 +1,53 @@
+// Package acr implements core.RegistryClient against Azure Container
+// Registry.
+package acr
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/pkg/registry/distclient"
+)
+
+// Client implements core.RegistryClient against Azure Container Registry.
+type Client struct {
+	*distclient.Client
+}
+
+// New would build an ACR registry client for the given registry hostname
+// (e.g. "myregistry.azurecr.io"), authenticating as the service principal
+// described by the AZURE_TENANT_ID, AZURE_CLIENT_ID, and
+// AZURE_CLIENT_SECRET environment variables. It currently always returns an
+// error: authenticating against ACR requires exchanging an ARM management-
+// plane access token for a registry-scoped refresh token via the registry's
+// own /oauth2/exchange endpoint, which this package does not yet do. Handing
+// the ARM token straight to the distribution client as a bearer token, as an
+// earlier version of this function did, 401s on every call, so New refuses
+// to build a client that looks functional but isn't until that exchange is
+// implemented.
+func New(registry string) (*Client, error) {
+	if registry == "" {
+		return nil, fmt.Errorf("a registry hostname is required for an acr target")
+	}
+
+	return nil, fmt.Errorf("acr target %q: not implemented: ACR support requires exchanging an ARM access token for a registry refresh token, which is not yet implemented", registry)
+}
+
+// ParseImageRef recognizes ACR image references of the form
+// "<registry>.azurecr.io/<repo>:<tag>".
+func (c *Client) ParseImageRef(image string) (repo, tag, digest string, ok bool) {
+	slash := strings.Index(image, "/")
+	if slash < 0 || !strings.HasSuffix(image[:slash], ".azurecr.io") {
+		return "", "", "", false
+	}
+
+	rest := image[slash+1:]
+	if at := strings.Index(rest, "@"); at >= 0 {
+		return rest[:at], "", rest[at+1:], true
+	}
+	if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+		return rest[:colon], rest[colon+1:], "", true
+	}
+
+	return rest, "", "", true
+}
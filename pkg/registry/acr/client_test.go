@@ -0,0 +1,17 @@
+package acr
+
+import "testing"
+
+func TestNewNotImplemented(t *testing.T) {
+	_, err := New("myregistry.azurecr.io")
+	if err == nil {
+		t.Fatal("Expected New to return an error, but got none")
+	}
+}
+
+func TestNewWithoutRegistry(t *testing.T) {
+	_, err := New("")
+	if err == nil {
+		t.Fatal("Expected an error when no registry hostname is set, but got none")
+	}
+}
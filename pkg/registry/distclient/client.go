@@ -0,0 +1,224 @@
+// Package distclient is a minimal Docker Registry HTTP API V2 client
+// shared by the gcr, acr, dockerhub, and harbor backends, which differ
+// from one another only in hostname and how they authenticate.
+package distclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/core"
+)
+
+// TokenSource returns the bearer token to present to the registry for the
+// given repository, refreshed as needed by the backend that owns it.
+type TokenSource func(repository string) (string, error)
+
+// StaticToken returns a TokenSource that always returns the given token,
+// for backends authenticated with a long-lived personal access token.
+func StaticToken(token string) TokenSource {
+	return func(string) (string, error) { return token, nil }
+}
+
+// Client is a Docker Registry HTTP API V2 client.
+type Client struct {
+	Host  string
+	Token TokenSource
+	HTTP  *http.Client
+
+	// basicAuth, when set by Authenticate, takes precedence over Token:
+	// imagePullSecret-discovered credentials override the backend's
+	// statically configured auth.
+	basicAuth *basicAuth
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+// New builds a distribution client for the given registry host, using
+// token to authenticate each request.
+func New(host string, token TokenSource) *Client {
+	return &Client{Host: host, Token: token, HTTP: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// ListRepositories returns the requested repositories. The v2 catalog API
+// does not support filtering server-side, so this returns the requested
+// names as-is, without contacting the registry or checking them; callers
+// pass the repository names they already know from
+// CleanupTarget.Repositories.
+func (c *Client) ListRepositories(repositoryNames []string) ([]*core.Repository, error) {
+	repos := make([]*core.Repository, len(repositoryNames))
+	for i, name := range repositoryNames {
+		repos[i] = &core.Repository{Name: name}
+	}
+	return repos, nil
+}
+
+// ListImages lists the tags of a repository and resolves each to its
+// manifest digest and size. The plain v2 API does not expose push time, so
+// callers relying on RetentionPolicy.MaxAge against these backends should
+// prefer KeepLastN or tag/label rules instead.
+func (c *Client) ListImages(repository string) ([]*core.Image, error) {
+	tags, err := c.listTags(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	images := make([]*core.Image, 0, len(tags))
+	for _, tag := range tags {
+		digest, size, err := c.headManifest(repository, tag)
+		if err != nil {
+			return nil, fmt.Errorf("resolving manifest for %s:%s: %s", repository, tag, err)
+		}
+		images = append(images, &core.Image{Digest: digest, Tags: []string{tag}, SizeInBytes: size})
+	}
+
+	return images, nil
+}
+
+// BatchRemoveImages deletes each image's manifest in turn; the v2 API has
+// no batch-delete endpoint.
+func (c *Client) BatchRemoveImages(repository string, images []*core.Image) error {
+	for _, image := range images {
+		if err := c.deleteManifest(repository, image.Digest); err != nil {
+			return fmt.Errorf("deleting %s@%s: %s", repository, image.Digest, err)
+		}
+	}
+	return nil
+}
+
+// ResolveDigest resolves a tag to its current manifest digest via a HEAD
+// request, as used internally by ListImages.
+func (c *Client) ResolveDigest(repository, tag string) (string, bool) {
+	digest, _, err := c.headManifest(repository, tag)
+	if err != nil || digest == "" {
+		return "", false
+	}
+	return digest, true
+}
+
+func (c *Client) listTags(repository string) ([]string, error) {
+	var out struct {
+		Tags []string `json:"tags"`
+	}
+
+	if err := c.getJSON(fmt.Sprintf("/v2/%s/tags/list", repository), repository, &out); err != nil {
+		return nil, err
+	}
+
+	return out.Tags, nil
+}
+
+func (c *Client) headManifest(repository, tag string) (digest string, size int64, err error) {
+	req, err := c.newRequest(http.MethodHead, fmt.Sprintf("/v2/%s/manifests/%s", repository, tag), repository)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Accept", strings.Join([]string{
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+	}, ", "))
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp.Header.Get("Docker-Content-Digest"), resp.ContentLength, nil
+}
+
+func (c *Client) deleteManifest(repository, digest string) error {
+	req, err := c.newRequest(http.MethodDelete, fmt.Sprintf("/v2/%s/manifests/%s", repository, digest), repository)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (c *Client) getJSON(path, repository string, out interface{}) error {
+	req, err := c.newRequest(http.MethodGet, path, repository)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) newRequest(method, path, repository string) (*http.Request, error) {
+	req, err := http.NewRequest(method, "https://"+c.Host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c.basicAuth != nil:
+		req.SetBasicAuth(c.basicAuth.username, c.basicAuth.password)
+
+	case c.Token != nil:
+		token, err := c.Token(repository)
+		if err != nil {
+			return nil, fmt.Errorf("fetching auth token: %s", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return req, nil
+}
+
+// RegistryHost returns the hostname this client talks to, satisfying
+// core.CredentialedClient.
+func (c *Client) RegistryHost() string {
+	return c.Host
+}
+
+// Authenticate configures the client to authenticate with auth's
+// credentials instead of its statically configured Token, satisfying
+// core.CredentialedClient. This is how a cluster's imagePullSecrets reach
+// a private registry that pkg/registry.New couldn't otherwise authenticate
+// against.
+func (c *Client) Authenticate(auth core.RegistryAuth) {
+	username, password := auth.Username, auth.Password
+
+	if username == "" && password == "" && auth.Auth != "" {
+		if decoded, err := base64.StdEncoding.DecodeString(auth.Auth); err == nil {
+			if user, pass, ok := strings.Cut(string(decoded), ":"); ok {
+				username, password = user, pass
+			}
+		}
+	}
+
+	c.basicAuth = &basicAuth{username: username, password: password}
+}
@@ -0,0 +1,62 @@
+// Package dockerhub implements core.RegistryClient against Docker Hub.
+package dockerhub
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/core"
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/pkg/registry/distclient"
+)
+
+const defaultHost = "index.docker.io"
+
+// Client implements core.RegistryClient against Docker Hub.
+type Client struct {
+	*distclient.Client
+}
+
+// New builds a Docker Hub registry client, authenticating with the bearer
+// token in the DOCKERHUB_TOKEN environment variable. registry may be empty,
+// in which case the default "index.docker.io" host is used.
+func New(registry string) (*Client, error) {
+	token := os.Getenv("DOCKERHUB_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("DOCKERHUB_TOKEN must be set for a dockerhub target")
+	}
+
+	host := registry
+	if host == "" {
+		host = defaultHost
+	}
+
+	return &Client{Client: distclient.New(host, distclient.StaticToken(token))}, nil
+}
+
+// ParseImageRef recognizes Docker Hub image references, which are the only
+// ones in a pod spec with no registry host component (e.g. "library/nginx:latest")
+// as well as the explicit "docker.io/..." and "index.docker.io/..." forms.
+func (c *Client) ParseImageRef(image string) (repo, tag, digest string, ok bool) {
+	slash := strings.Index(image, "/")
+	rest := image
+
+	if slash >= 0 {
+		host := image[:slash]
+		if host == "docker.io" || host == defaultHost {
+			rest = image[slash+1:]
+		} else if strings.Contains(host, ".") || strings.Contains(host, ":") {
+			// Has a registry host that isn't Docker Hub.
+			return "", "", "", false
+		}
+	}
+
+	if at := strings.Index(rest, "@"); at >= 0 {
+		return rest[:at], "", rest[at+1:], true
+	}
+	if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+		return rest[:colon], rest[colon+1:], "", true
+	}
+
+	return rest, "", "", true
+}
@@ -0,0 +1,182 @@
+// Package ecr implements core.RegistryClient against Amazon ECR.
+package ecr
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awsecr "github.com/aws/aws-sdk-go/service/ecr"
+
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/core"
+)
+
+// Multi-arch manifest media types, as defined by the Docker distribution
+// spec and the OCI image spec respectively.
+const (
+	manifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+	imageIndexMediaType   = "application/vnd.oci.image.index.v1+json"
+)
+
+// Client implements core.RegistryClient against Amazon ECR.
+type Client struct {
+	api *awsecr.ECR
+}
+
+// New builds an ECR registry client for the given region, authenticated via
+// the ambient AWS credentials (environment, shared config, or an
+// instance/IRSA role).
+func New(region string) (*Client, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, fmt.Errorf("creating AWS session: %s", err)
+	}
+
+	return &Client{api: awsecr.New(sess)}, nil
+}
+
+func (c *Client) ListRepositories(repositoryNames []string) ([]*core.Repository, error) {
+	names := make([]*string, len(repositoryNames))
+	for i := range repositoryNames {
+		names[i] = aws.String(repositoryNames[i])
+	}
+
+	out, err := c.api.DescribeRepositories(&awsecr.DescribeRepositoriesInput{RepositoryNames: names})
+	if err != nil {
+		return nil, err
+	}
+
+	repos := make([]*core.Repository, len(out.Repositories))
+	for i, r := range out.Repositories {
+		repos[i] = &core.Repository{Name: aws.StringValue(r.RepositoryName)}
+	}
+
+	return repos, nil
+}
+
+func (c *Client) ListImages(repositoryName string) ([]*core.Image, error) {
+	var images []*core.Image
+
+	err := c.api.DescribeImagesPages(&awsecr.DescribeImagesInput{
+		RepositoryName: aws.String(repositoryName),
+	}, func(page *awsecr.DescribeImagesOutput, lastPage bool) bool {
+		for _, detail := range page.ImageDetails {
+			images = append(images, toImage(detail))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return images, nil
+}
+
+func (c *Client) BatchRemoveImages(repositoryName string, images []*core.Image) error {
+	ids := make([]*awsecr.ImageIdentifier, len(images))
+	for i, image := range images {
+		ids[i] = &awsecr.ImageIdentifier{ImageDigest: aws.String(image.Digest)}
+	}
+
+	_, err := c.api.BatchDeleteImage(&awsecr.BatchDeleteImageInput{
+		RepositoryName: aws.String(repositoryName),
+		ImageIds:       ids,
+	})
+	return err
+}
+
+// ParseImageRef recognizes ECR image references of the form
+// "<account>.dkr.ecr.<region>.amazonaws.com/<repo>:<tag>" or
+// "...amazonaws.com/<repo>@sha256:<digest>".
+func (c *Client) ParseImageRef(image string) (repo, tag, digest string, ok bool) {
+	slash := strings.Index(image, "/")
+	if slash < 0 {
+		return "", "", "", false
+	}
+
+	host := image[:slash]
+	if !strings.Contains(host, ".dkr.ecr.") || !strings.HasSuffix(host, ".amazonaws.com") {
+		return "", "", "", false
+	}
+
+	rest := image[slash+1:]
+	if at := strings.Index(rest, "@"); at >= 0 {
+		return rest[:at], "", rest[at+1:], true
+	}
+	if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+		return rest[:colon], rest[colon+1:], "", true
+	}
+
+	return rest, "", "", true
+}
+
+// ResolveDigest resolves a tag to its current image digest via ECR's
+// BatchGetImage, which accepts a tag as an ImageIdentifier and returns the
+// digest it currently resolves to.
+func (c *Client) ResolveDigest(repository, tag string) (string, bool) {
+	out, err := c.api.BatchGetImage(&awsecr.BatchGetImageInput{
+		RepositoryName: aws.String(repository),
+		ImageIds:       []*awsecr.ImageIdentifier{{ImageTag: aws.String(tag)}},
+	})
+	if err != nil || len(out.Images) == 0 || out.Images[0].ImageId == nil {
+		return "", false
+	}
+
+	digest := aws.StringValue(out.Images[0].ImageId.ImageDigest)
+	return digest, digest != ""
+}
+
+// ResolveManifest fetches an image's manifest via ECR's BatchGetImage and
+// reports its child digests if it is a multi-arch manifest list or OCI
+// image index.
+func (c *Client) ResolveManifest(repository, digest string) ([]string, bool) {
+	out, err := c.api.BatchGetImage(&awsecr.BatchGetImageInput{
+		RepositoryName: aws.String(repository),
+		ImageIds:       []*awsecr.ImageIdentifier{{ImageDigest: aws.String(digest)}},
+	})
+	if err != nil || len(out.Images) == 0 || out.Images[0].ImageManifest == nil {
+		return nil, false
+	}
+
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+	}
+
+	if err := json.Unmarshal([]byte(*out.Images[0].ImageManifest), &manifest); err != nil {
+		return nil, false
+	}
+
+	if manifest.MediaType != manifestListMediaType && manifest.MediaType != imageIndexMediaType {
+		return nil, false
+	}
+
+	children := make([]string, len(manifest.Manifests))
+	for i, m := range manifest.Manifests {
+		children[i] = m.Digest
+	}
+
+	return children, true
+}
+
+func toImage(detail *awsecr.ImageDetail) *core.Image {
+	image := &core.Image{Digest: aws.StringValue(detail.ImageDigest)}
+
+	for _, tag := range detail.ImageTags {
+		image.Tags = append(image.Tags, aws.StringValue(tag))
+	}
+
+	if detail.ImagePushedAt != nil {
+		image.PushedAt = *detail.ImagePushedAt
+	}
+
+	if detail.ImageSizeInBytes != nil {
+		image.SizeInBytes = *detail.ImageSizeInBytes
+	}
+
+	return image
+}
@@ -0,0 +1,37 @@
+// Package registry builds the core.RegistryClient for a CleanupTarget,
+// dispatching to the concrete backend implementation under
+// pkg/registry/{ecr,gcr,acr,dockerhub,harbor}.
+package registry
+
+import (
+	"fmt"
+
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/core"
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/pkg/registry/acr"
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/pkg/registry/dockerhub"
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/pkg/registry/ecr"
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/pkg/registry/gcr"
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/pkg/registry/harbor"
+)
+
+// New builds the RegistryClient for a CleanupTarget's backend, authenticating
+// using the convention appropriate to that backend: the ambient AWS
+// credentials for ECR, GKE workload identity for GCR, and a bearer token for
+// Docker Hub and Harbor. An ACR target always fails: acr.New returns an
+// error for every registry.
+func New(target *core.CleanupTarget) (core.RegistryClient, error) {
+	switch target.Backend {
+	case core.BackendECR:
+		return ecr.New(target.Region)
+	case core.BackendGCR:
+		return gcr.New(target.Project, target.Registry)
+	case core.BackendACR:
+		return acr.New(target.Registry)
+	case core.BackendDockerHub:
+		return dockerhub.New(target.Registry)
+	case core.BackendHarbor:
+		return harbor.New(target.Registry)
+	default:
+		return nil, fmt.Errorf("unknown registry backend %q", target.Backend)
+	}
+}
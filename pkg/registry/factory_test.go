@@ -0,0 +1,28 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/core"
+)
+
+func TestNewUnknownBackend(t *testing.T) {
+	_, err := New(&core.CleanupTarget{Backend: core.Backend("unknown")})
+	if err == nil {
+		t.Fatal("Expected an error for an unknown backend, but got none")
+	}
+}
+
+func TestNewDockerHubWithoutToken(t *testing.T) {
+	_, err := New(&core.CleanupTarget{Backend: core.BackendDockerHub})
+	if err == nil {
+		t.Fatal("Expected an error when DOCKERHUB_TOKEN is unset, but got none")
+	}
+}
+
+func TestNewHarborWithoutRegistry(t *testing.T) {
+	_, err := New(&core.CleanupTarget{Backend: core.BackendHarbor})
+	if err == nil {
+		t.Fatal("Expected an error when no registry hostname is set, but got none")
+	}
+}
@@ -0,0 +1,80 @@
+// Package gcr implements core.RegistryClient against Google Container
+// Registry and Artifact Registry.
+package gcr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/pkg/registry/distclient"
+)
+
+// defaultHost is the registry host used when a gcr target doesn't override
+// it with a regional mirror or Artifact Registry host.
+const defaultHost = "gcr.io"
+
+// Client implements core.RegistryClient against GCR/Artifact Registry.
+type Client struct {
+	*distclient.Client
+
+	host string
+}
+
+// New builds a GCR registry client for the given GCP project, using the
+// workload identity credentials available to the pod to mint registry
+// bearer tokens. host overrides the default "gcr.io" host, for a regional
+// mirror (e.g. "eu.gcr.io") or an Artifact Registry host (e.g.
+// "us-docker.pkg.dev"); an empty host uses the default.
+func New(project, host string) (*Client, error) {
+	if project == "" {
+		return nil, fmt.Errorf("a GCP project is required for a gcr target")
+	}
+
+	if host == "" {
+		host = defaultHost
+	}
+
+	creds, err := google.FindDefaultCredentials(context.Background(), "https://www.googleapis.com/auth/devstorage.read_write")
+	if err != nil {
+		return nil, fmt.Errorf("loading GCP workload identity credentials: %s", err)
+	}
+
+	token := func(string) (string, error) {
+		t, err := creds.TokenSource.Token()
+		if err != nil {
+			return "", err
+		}
+		return t.AccessToken, nil
+	}
+
+	return &Client{Client: distclient.New(host, token), host: host}, nil
+}
+
+// ParseImageRef recognizes image references against this client's own host:
+// either the default "gcr.io" (and its regional "<region>.gcr.io" mirrors)
+// or the Artifact Registry host configured for this target (e.g.
+// "<region>-docker.pkg.dev").
+func (c *Client) ParseImageRef(image string) (repo, tag, digest string, ok bool) {
+	slash := strings.Index(image, "/")
+	if slash < 0 {
+		return "", "", "", false
+	}
+
+	host := image[:slash]
+	if host != c.host && !(c.host == defaultHost && strings.HasSuffix(host, ".gcr.io")) {
+		return "", "", "", false
+	}
+
+	rest := image[slash+1:]
+	if at := strings.Index(rest, "@"); at >= 0 {
+		return rest[:at], "", rest[at+1:], true
+	}
+	if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+		return rest[:colon], rest[colon+1:], "", true
+	}
+
+	return rest, "", "", true
+}
@@ -0,0 +1,33 @@
+package gcr
+
+import "testing"
+
+func TestParseImageRefMatchesOnlyItsOwnHost(t *testing.T) {
+	tests := []struct {
+		name  string
+		host  string
+		image string
+		ok    bool
+	}{
+		{"default host matches gcr.io", "", "gcr.io/my-project/my-repo:v1", true},
+		{"default host matches a regional gcr.io mirror", "", "eu.gcr.io/my-project/my-repo:v1", true},
+		{"default host does not match an Artifact Registry host", "", "us-docker.pkg.dev/my-project/my-repo:v1", false},
+		{"Artifact Registry host matches itself", "us-docker.pkg.dev", "us-docker.pkg.dev/my-project/my-repo:v1", true},
+		{"Artifact Registry host does not match gcr.io", "us-docker.pkg.dev", "gcr.io/my-project/my-repo:v1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := tt.host
+			if host == "" {
+				host = defaultHost
+			}
+			client := &Client{host: host}
+
+			_, _, _, ok := client.ParseImageRef(tt.image)
+			if ok != tt.ok {
+				t.Errorf("Expected ok=%v for image %q against host %q, but got %v", tt.ok, tt.image, host, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Package harbor implements core.RegistryClient against a self-hosted
+// Harbor registry.
+package harbor
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/core"
+	"github.com/vatit-devops/kube-ecr-cleanup-controller/pkg/registry/distclient"
+)
+
+// Client implements core.RegistryClient against a self-hosted Harbor
+// registry.
+type Client struct {
+	host string
+	*distclient.Client
+}
+
+// New builds a Harbor registry client for the given registry hostname,
+// authenticating with the bearer token in the HARBOR_TOKEN environment
+// variable. Unlike the other hosted backends, registry is required: Harbor
+// is self-hosted and has no well-known default host.
+func New(registry string) (*Client, error) {
+	if registry == "" {
+		return nil, fmt.Errorf("a registry hostname is required for a harbor target")
+	}
+
+	token := os.Getenv("HARBOR_TOKEN")
+	if token == "" {
+		return nil, fmt.Errorf("HARBOR_TOKEN must be set for a harbor target")
+	}
+
+	return &Client{host: registry, Client: distclient.New(registry, distclient.StaticToken(token))}, nil
+}
+
+// ParseImageRef recognizes image references whose host matches this
+// client's configured Harbor registry, of the form
+// "<registry>/<project>/<repo>:<tag>".
+func (c *Client) ParseImageRef(image string) (repo, tag, digest string, ok bool) {
+	slash := strings.Index(image, "/")
+	if slash < 0 || image[:slash] != c.host {
+		return "", "", "", false
+	}
+
+	rest := image[slash+1:]
+	if at := strings.Index(rest, "@"); at >= 0 {
+		return rest[:at], "", rest[at+1:], true
+	}
+	if colon := strings.LastIndex(rest, ":"); colon >= 0 {
+		return rest[:colon], rest[colon+1:], "", true
+	}
+
+	return rest, "", "", true
+}
@@ -0,0 +1,44 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+)
+
+// debouncer coalesces repeated events for the same repository into a
+// single dispatch call, fired window after the most recent event for that
+// repository.
+type debouncer struct {
+	mu       sync.Mutex
+	window   time.Duration
+	timers   map[string]*time.Timer
+	dispatch func(repository string)
+}
+
+func newDebouncer(window time.Duration, dispatch func(repository string)) *debouncer {
+	return &debouncer{
+		window:   window,
+		timers:   map[string]*time.Timer{},
+		dispatch: dispatch,
+	}
+}
+
+// enqueue schedules a dispatch for repository, resetting the window if one
+// is already pending.
+func (d *debouncer) enqueue(repository string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if timer, ok := d.timers[repository]; ok {
+		timer.Reset(d.window)
+		return
+	}
+
+	d.timers[repository] = time.AfterFunc(d.window, func() {
+		d.mu.Lock()
+		delete(d.timers, repository)
+		d.mu.Unlock()
+
+		d.dispatch(repository)
+	})
+}
@@ -0,0 +1,80 @@
+package webhook
+
+import "encoding/json"
+
+// snsEnvelope is the outer JSON object an SNS HTTPS subscription posts for
+// every delivery, whether it carries a push event notification or the
+// one-time subscription handshake. See
+// https://docs.aws.amazon.com/sns/latest/dg/sns-message-and-json-formats.html.
+type snsEnvelope struct {
+	Type         string `json:"Type"`
+	Message      string `json:"Message"`
+	SubscribeURL string `json:"SubscribeURL"`
+	TopicArn     string `json:"TopicArn"`
+}
+
+// pushEvent is the EventBridge envelope AWS sends for an "ECR Image Action"
+// event, trimmed to the fields this package cares about. It is delivered
+// either directly (an API Gateway -> Lambda bridge forwards the raw event)
+// or as the JSON-encoded Message of an snsEnvelope (an SNS HTTPS
+// subscription).
+type pushEvent struct {
+	DetailType string `json:"detail-type"`
+	Source     string `json:"source"`
+	Detail     struct {
+		ActionType     string `json:"action-type"`
+		Result         string `json:"result"`
+		RepositoryName string `json:"repository-name"`
+		ImageTag       string `json:"image-tag"`
+		ImageDigest    string `json:"image-digest"`
+	} `json:"detail"`
+}
+
+// isSuccessfulPush reports whether the event describes an image that was
+// successfully pushed, as opposed to a delete action or a failed push.
+func (e *pushEvent) isSuccessfulPush() bool {
+	return e.Detail.ActionType == "PUSH" && e.Detail.Result == "SUCCESS"
+}
+
+// parsePushEvent decodes an "ECR Image Action" event, unwrapping an
+// snsEnvelope's Message first if the body is SNS-Notification-shaped. It
+// reports ok=false for malformed JSON, an SNS envelope that isn't a
+// Notification (such as a subscription handshake, handled separately by
+// subscriptionConfirmationURL), or an event missing a repository name.
+func parsePushEvent(body []byte) (*pushEvent, bool) {
+	var envelope snsEnvelope
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Type != "" {
+		if envelope.Type != "Notification" {
+			return nil, false
+		}
+		return parsePushEvent([]byte(envelope.Message))
+	}
+
+	var event pushEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, false
+	}
+
+	if event.Detail.RepositoryName == "" {
+		return nil, false
+	}
+
+	return &event, true
+}
+
+// subscriptionConfirmationURL reports the SubscribeURL of an SNS
+// SubscriptionConfirmation handshake, if body is one. SNS sends this once,
+// the first time a topic is subscribed to this endpoint, and expects the
+// subscriber to fetch SubscribeURL to confirm it owns the endpoint.
+func subscriptionConfirmationURL(body []byte) (string, bool) {
+	var envelope snsEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return "", false
+	}
+
+	if envelope.Type != "SubscriptionConfirmation" || envelope.SubscribeURL == "" {
+		return "", false
+	}
+
+	return envelope.SubscribeURL, true
+}
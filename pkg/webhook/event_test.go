@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParsePushEventUnwrapsSNSNotification(t *testing.T) {
+	inner := `{"detail-type":"ECR Image Action","source":"aws.ecr","detail":{"action-type":"PUSH","result":"SUCCESS","repository-name":"my-repo","image-tag":"latest","image-digest":"sha256:abc"}}`
+	innerJSON, err := json.Marshal(inner)
+	if err != nil {
+		t.Fatalf("encoding inner message: %s", err)
+	}
+	body := []byte(`{"Type":"Notification","TopicArn":"arn:aws:sns:us-east-1:111111111111:ecr-pushes","Message":` + string(innerJSON) + `}`)
+
+	event, ok := parsePushEvent(body)
+	if !ok {
+		t.Fatal("Expected parsePushEvent to unwrap an SNS Notification, but it returned ok=false")
+	}
+	if event.Detail.RepositoryName != "my-repo" {
+		t.Errorf("Expected repository name my-repo, but got %v", event.Detail.RepositoryName)
+	}
+	if !event.isSuccessfulPush() {
+		t.Error("Expected the unwrapped event to be a successful push")
+	}
+}
+
+func TestParsePushEventRejectsOtherSNSTypes(t *testing.T) {
+	body := []byte(`{"Type":"UnsubscribeConfirmation","TopicArn":"arn:aws:sns:us-east-1:111111111111:ecr-pushes"}`)
+
+	if _, ok := parsePushEvent(body); ok {
+		t.Error("Expected parsePushEvent to reject a non-Notification SNS envelope")
+	}
+}
+
+func TestSubscriptionConfirmationURL(t *testing.T) {
+	body := []byte(`{"Type":"SubscriptionConfirmation","SubscribeURL":"https://sns.us-east-1.amazonaws.com/confirm","TopicArn":"arn:aws:sns:us-east-1:111111111111:ecr-pushes"}`)
+
+	url, ok := subscriptionConfirmationURL(body)
+	if !ok {
+		t.Fatal("Expected a subscription confirmation URL, but got ok=false")
+	}
+	if url != "https://sns.us-east-1.amazonaws.com/confirm" {
+		t.Errorf("Expected the SubscribeURL to be returned verbatim, but got %v", url)
+	}
+}
+
+func TestSubscriptionConfirmationURLIgnoresNotifications(t *testing.T) {
+	if _, ok := subscriptionConfirmationURL(pushEventBody("my-repo")); ok {
+		t.Error("Expected a bare push event not to be mistaken for a subscription confirmation")
+	}
+}
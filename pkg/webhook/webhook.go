@@ -0,0 +1,162 @@
+// Package webhook runs an HTTP listener for ECR image-push notifications,
+// delivered via AWS EventBridge (either an SNS HTTPS subscription or an API
+// Gateway -> Lambda bridge), and turns them into debounced, per-repository
+// cleanup dispatches.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// signatureHeader carries the hex-encoded HMAC-SHA256 of the request body,
+// computed with the shared secret configured on both sides of the bridge.
+const signatureHeader = "X-Ecr-Cleanup-Signature"
+
+// Config configures a Server.
+type Config struct {
+	// Addr is the address the Server listens on, e.g. ":8443".
+	Addr string
+
+	// Secret is the HMAC shared secret used to verify the signatureHeader
+	// of incoming requests. A Server with an empty Secret accepts
+	// unsigned requests, which is only appropriate for local testing.
+	Secret string
+
+	// DebounceWindow is how long the Server waits after the most recent
+	// push event for a repository before dispatching a cleanup for it,
+	// coalescing a burst of pushes (e.g. a CI job pushing many tags in a
+	// row) into a single run.
+	DebounceWindow time.Duration
+}
+
+// Server receives ECR push events over HTTP and dispatches a debounced,
+// per-repository callback in response.
+type Server struct {
+	secret    []byte
+	debouncer *debouncer
+	http      *http.Client
+}
+
+// NewServer builds a Server that calls dispatch with the name of the
+// affected repository once DebounceWindow has passed since its last push
+// event.
+func NewServer(config Config, dispatch func(repository string)) *Server {
+	return &Server{
+		secret:    []byte(config.Secret),
+		debouncer: newDebouncer(config.DebounceWindow, dispatch),
+		http:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ServeHTTP verifies and parses an incoming ECR push event and enqueues it
+// for debounced dispatch. It responds 401 on a signature mismatch, 204 for
+// a body that doesn't parse as an event or is an event that isn't a
+// successful image push, and 202 once the event has been enqueued. An SNS subscription confirmation handshake is
+// confirmed automatically and responds 200; it carries no HMAC signature of
+// our own, since it comes directly from SNS, so it is handled before
+// signature verification.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "reading request body", http.StatusBadRequest)
+		return
+	}
+
+	if confirmURL, ok := subscriptionConfirmationURL(body); ok {
+		if err := s.confirmSNSSubscription(confirmURL); err != nil {
+			http.Error(w, "confirming SNS subscription: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if !verifySignature(s.secret, body, r.Header.Get(signatureHeader)) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event, ok := parsePushEvent(body)
+	if !ok || !event.isSuccessfulPush() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.debouncer.enqueue(event.Detail.RepositoryName)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// confirmSNSSubscription fetches confirmURL to complete an SNS HTTPS
+// subscription handshake. It refuses any URL that isn't an
+// https://*.amazonaws.com host, since confirmURL comes from an
+// unauthenticated request body and fetching an attacker-controlled URL
+// would otherwise be a server-side request forgery.
+func (s *Server) confirmSNSSubscription(confirmURL string) error {
+	parsed, err := url.Parse(confirmURL)
+	if err != nil {
+		return fmt.Errorf("parsing SubscribeURL: %s", err)
+	}
+
+	if parsed.Scheme != "https" || !strings.HasSuffix(parsed.Hostname(), ".amazonaws.com") {
+		return fmt.Errorf("refusing to fetch SubscribeURL with untrusted host %q", parsed.Hostname())
+	}
+
+	resp, err := s.http.Get(confirmURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
+
+// ListenAndServe serves the Server as plain HTTP on config.Addr until ctx
+// is canceled, at which point it shuts down gracefully. It does not
+// terminate TLS itself.
+func (s *Server) ListenAndServe(ctx context.Context, config Config) error {
+	httpServer := &http.Server{Addr: config.Addr, Handler: s}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return httpServer.Shutdown(context.Background())
+	case err := <-errs:
+		return err
+	}
+}
+
+// verifySignature reports whether signature is the hex-encoded
+// HMAC-SHA256 of body under secret. An empty secret disables verification.
+func verifySignature(secret, body []byte, signature string) bool {
+	if len(secret) == 0 {
+		return true
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,136 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSecret = "test-secret"
+
+func sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func pushEventBody(repository string) []byte {
+	return []byte(`{
+		"detail-type": "ECR Image Action",
+		"source": "aws.ecr",
+		"detail": {
+			"action-type": "PUSH",
+			"result": "SUCCESS",
+			"repository-name": "` + repository + `",
+			"image-tag": "latest",
+			"image-digest": "sha256:abc"
+		}
+	}`)
+}
+
+func TestServerDispatchesOnValidSignature(t *testing.T) {
+	var mu sync.Mutex
+	var dispatched []string
+
+	server := NewServer(Config{Secret: testSecret, DebounceWindow: time.Millisecond}, func(repository string) {
+		mu.Lock()
+		dispatched = append(dispatched, repository)
+		mu.Unlock()
+	})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	body := pushEventBody("my-repo")
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(body))
+	req.Header.Set(signatureHeader, sign(body))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("posting event: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected status %d, but got %d", http.StatusAccepted, resp.StatusCode)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(dispatched) != 1 || dispatched[0] != "my-repo" {
+		t.Errorf("Expected a single dispatch for my-repo, but got %v", dispatched)
+	}
+}
+
+func TestServerRejectsInvalidSignature(t *testing.T) {
+	dispatched := false
+
+	server := NewServer(Config{Secret: testSecret, DebounceWindow: time.Millisecond}, func(repository string) {
+		dispatched = true
+	})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	body := pushEventBody("my-repo")
+	req, _ := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(body))
+	req.Header.Set(signatureHeader, "not-the-right-signature")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("posting event: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, but got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if dispatched {
+		t.Error("Expected dispatch not to be called for a request with an invalid signature")
+	}
+}
+
+func TestServerDebouncesBurstOfEvents(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+
+	server := NewServer(Config{Secret: testSecret, DebounceWindow: 50 * time.Millisecond}, func(repository string) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+	})
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	for i := 0; i < 20; i++ {
+		body := pushEventBody("my-repo")
+		req, _ := http.NewRequest(http.MethodPost, ts.URL, bytes.NewReader(body))
+		req.Header.Set(signatureHeader, sign(body))
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("posting event: %s", err)
+		}
+		resp.Body.Close()
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 1 {
+		t.Errorf("Expected a burst of pushes to the same repository to produce exactly 1 dispatch, but got %d", calls)
+	}
+}